@@ -0,0 +1,62 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// migrate-blob 将保存在数据库中的图片数据(包括历史版本)迁移至bucket设置的storage，
+// 使用与服务相同的配置(GO_ENV、DATABASE_URI等)
+//
+//	go run ./cmd/migrate-blob -bucket=test -batch=100 -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/vicanso/tiny-site/helper"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+func main() {
+	params := storage.MigrateImageBlobParams{}
+	flag.StringVar(&params.Bucket, "bucket", "", "only migrate images of the bucket")
+	flag.IntVar(&params.BatchSize, "batch", 100, "count of images for each batch")
+	flag.BoolVar(&params.DryRun, "dry-run", false, "only count images to be migrated")
+	flag.Parse()
+
+	defer func() {
+		_ = helper.EntGetClient().Close()
+	}()
+	ctx := context.Background()
+	// 初始化storage，用于保存图片数据
+	err := storage.InitImageFinder(ctx)
+	if err != nil {
+		exit(err)
+	}
+	result, err := storage.MigrateImageBlobs(ctx, params)
+	if err != nil {
+		exit(fmt.Errorf("migrate %d images and %d versions then fail, %w", result.Images, result.Versions, err))
+	}
+	if params.DryRun {
+		fmt.Printf("%d images and %d versions should be migrated\n", result.Images, result.Versions)
+		return
+	}
+	fmt.Printf("migrate %d images and %d versions success\n", result.Images, result.Versions)
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	_ = helper.EntGetClient().Close()
+	os.Exit(1)
+}
@@ -163,6 +163,55 @@ type (
 	TinyConfig struct {
 		Addr string `validate:"required,hostname_port"`
 	}
+	// ImageFetchConfig 获取外部图片(proxy与http storage)的配置
+	ImageFetchConfig struct {
+		// 允许的host或IP/CIDR，为空则除禁止的均允许
+		Allow []string
+		// 禁止的host或IP/CIDR
+		Deny []string
+		// 最大的数据长度(字节)
+		MaxSize int `validate:"min=1"`
+		// 超时
+		Timeout time.Duration `validate:"required"`
+	}
+	// ImageLimitConfig 解码图片的限制，避免解码超大图片时耗尽内存
+	ImageLimitConfig struct {
+		// 最大宽度
+		MaxWidth int `validate:"min=1"`
+		// 最大高度
+		MaxHeight int `validate:"min=1"`
+		// 最大像素数量(宽*高，动图为所有帧的总和)
+		MaxPixels int `validate:"min=1"`
+		// 最大的数据长度(字节)
+		MaxSize int `validate:"min=1"`
+	}
+	// ImageTrashConfig 图片回收站的配置
+	ImageTrashConfig struct {
+		// 删除的图片保留时长，超过则清除
+		Retention time.Duration `validate:"required"`
+		// 每次清除的数量
+		BatchSize int `validate:"min=1"`
+	}
+	// ImageImportConfig 批量上传与压缩包导入图片的配置
+	ImageImportConfig struct {
+		// 每次导入的最大文件数量
+		MaxFiles int `validate:"min=1"`
+		// 压缩包的最大数据长度(字节)
+		MaxArchiveSize int `validate:"min=1"`
+		// 压缩包超过此长度(字节)则以异步任务处理
+		AsyncSize int `validate:"min=0"`
+		// 异步任务的状态保留时长
+		JobTTL time.Duration `validate:"required"`
+	}
+	// PipelineCacheConfig pipeline处理结果的缓存配置
+	PipelineCacheConfig struct {
+		// 内存缓存(lru)的数量
+		LRUSize int `validate:"min=0"`
+		// 缓存有效期，小于1秒则不缓存
+		TTL time.Duration
+		// 可缓存的最大数据长度(字节)，超过的不缓存
+		MaxSize int `validate:"min=0"`
+	}
 )
 
 // mustLoadConfig 加载配置，出错是则抛出panic
@@ -444,3 +493,65 @@ func MustGetTinyConfig() *TinyConfig {
 	mustValidate(tinyConfig)
 	return tinyConfig
 }
+
+// MustGetPipelineCacheConfig 获取pipeline处理结果的缓存配置
+func MustGetPipelineCacheConfig() *PipelineCacheConfig {
+	prefix := "pipelineCache."
+	pipelineCacheConfig := &PipelineCacheConfig{
+		LRUSize: defaultViperX.GetIntFromENV(prefix + "lruSize"),
+		TTL:     defaultViperX.GetDurationFromENV(prefix + "ttl"),
+		MaxSize: defaultViperX.GetIntFromENV(prefix + "maxSize"),
+	}
+	mustValidate(pipelineCacheConfig)
+	return pipelineCacheConfig
+}
+
+// MustGetImageFetchConfig 获取外部图片的配置
+func MustGetImageFetchConfig() *ImageFetchConfig {
+	prefix := "imageFetch."
+	imageFetchConfig := &ImageFetchConfig{
+		Allow:   defaultViperX.GetStringSlice(prefix + "allow"),
+		Deny:    defaultViperX.GetStringSlice(prefix + "deny"),
+		MaxSize: defaultViperX.GetIntFromENV(prefix + "maxSize"),
+		Timeout: defaultViperX.GetDurationFromENV(prefix + "timeout"),
+	}
+	mustValidate(imageFetchConfig)
+	return imageFetchConfig
+}
+
+// MustGetImageLimitConfig 获取解码图片的限制配置
+func MustGetImageLimitConfig() *ImageLimitConfig {
+	prefix := "imageLimit."
+	imageLimitConfig := &ImageLimitConfig{
+		MaxWidth:  defaultViperX.GetIntFromENV(prefix + "maxWidth"),
+		MaxHeight: defaultViperX.GetIntFromENV(prefix + "maxHeight"),
+		MaxPixels: defaultViperX.GetIntFromENV(prefix + "maxPixels"),
+		MaxSize:   defaultViperX.GetIntFromENV(prefix + "maxSize"),
+	}
+	mustValidate(imageLimitConfig)
+	return imageLimitConfig
+}
+
+// MustGetImageImportConfig 获取批量导入图片的配置
+func MustGetImageImportConfig() *ImageImportConfig {
+	prefix := "imageImport."
+	imageImportConfig := &ImageImportConfig{
+		MaxFiles:       defaultViperX.GetIntFromENV(prefix + "maxFiles"),
+		MaxArchiveSize: defaultViperX.GetIntFromENV(prefix + "maxArchiveSize"),
+		AsyncSize:      defaultViperX.GetIntFromENV(prefix + "asyncSize"),
+		JobTTL:         defaultViperX.GetDurationFromENV(prefix + "jobTTL"),
+	}
+	mustValidate(imageImportConfig)
+	return imageImportConfig
+}
+
+// MustGetImageTrashConfig 获取图片回收站的配置
+func MustGetImageTrashConfig() *ImageTrashConfig {
+	prefix := "imageTrash."
+	imageTrashConfig := &ImageTrashConfig{
+		Retention: defaultViperX.GetDurationFromENV(prefix + "retention"),
+		BatchSize: defaultViperX.GetIntFromENV(prefix + "batchSize"),
+	}
+	mustValidate(imageTrashConfig)
+	return imageTrashConfig
+}
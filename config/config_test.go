@@ -109,3 +109,51 @@ func TestMustGetMinioConfig(t *testing.T) {
 	assert.Equal("test123456", minioConfig.SecretAccessKey)
 	assert.False(minioConfig.SSL)
 }
+
+func TestMustGetPipelineCacheConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	pipelineCacheConfig := MustGetPipelineCacheConfig()
+	assert.Equal(200, pipelineCacheConfig.LRUSize)
+	assert.Equal(10*time.Minute, pipelineCacheConfig.TTL)
+	assert.Equal(1048576, pipelineCacheConfig.MaxSize)
+}
+
+func TestMustGetImageFetchConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	imageFetchConfig := MustGetImageFetchConfig()
+	assert.Empty(imageFetchConfig.Allow)
+	assert.Contains(imageFetchConfig.Deny, "127.0.0.0/8")
+	assert.Contains(imageFetchConfig.Deny, "169.254.0.0/16")
+	assert.Equal(20971520, imageFetchConfig.MaxSize)
+	assert.Equal(10*time.Second, imageFetchConfig.Timeout)
+}
+
+func TestMustGetImageLimitConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	imageLimitConfig := MustGetImageLimitConfig()
+	assert.Equal(10000, imageLimitConfig.MaxWidth)
+	assert.Equal(10000, imageLimitConfig.MaxHeight)
+	assert.Equal(40000000, imageLimitConfig.MaxPixels)
+	assert.Equal(20971520, imageLimitConfig.MaxSize)
+}
+
+func TestMustGetImageImportConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	imageImportConfig := MustGetImageImportConfig()
+	assert.Equal(1000, imageImportConfig.MaxFiles)
+	assert.Equal(500*1024*1024, imageImportConfig.MaxArchiveSize)
+	assert.Equal(10*1024*1024, imageImportConfig.AsyncSize)
+	assert.Equal(24*time.Hour, imageImportConfig.JobTTL)
+}
+
+func TestMustGetImageTrashConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	imageTrashConfig := MustGetImageTrashConfig()
+	assert.Equal(720*time.Hour, imageTrashConfig.Retention)
+	assert.Equal(100, imageTrashConfig.BatchSize)
+}
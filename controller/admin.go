@@ -20,6 +20,7 @@ import (
 	"github.com/vicanso/elton"
 	"github.com/vicanso/tiny-site/cs"
 	"github.com/vicanso/tiny-site/helper"
+	"github.com/vicanso/tiny-site/pipeline"
 	"github.com/vicanso/tiny-site/router"
 )
 
@@ -29,6 +30,9 @@ type (
 	findCacheResp struct {
 		Data string `json:"data"`
 	}
+	cleanPipelineCacheResp struct {
+		Count int `json:"count"`
+	}
 )
 
 func init() {
@@ -46,6 +50,12 @@ func init() {
 		newTrackerMiddleware(cs.ActionAdminCleanCache),
 		ctrl.cleanCacheByKey,
 	)
+	// 清除图片相关的pipeline缓存
+	g.DELETE(
+		"/v1/pipeline-caches/{bucket}/{name}",
+		newTrackerMiddleware(cs.ActionAdminCleanPipelineCache),
+		ctrl.cleanPipelineCache,
+	)
 }
 
 // findCacheByKey find cache by key
@@ -69,3 +79,15 @@ func (*adminCtrl) cleanCacheByKey(c *elton.Context) error {
 	c.NoContent()
 	return nil
 }
+
+// cleanPipelineCache clean pipeline cache of image
+func (*adminCtrl) cleanPipelineCache(c *elton.Context) error {
+	count, err := pipeline.PurgeResultCache(c.Context(), c.Param("bucket"), c.Param("name"))
+	if err != nil {
+		return err
+	}
+	c.Body = &cleanPipelineCacheResp{
+		Count: count,
+	}
+	return nil
+}
@@ -104,10 +104,18 @@ func getImageClient() *ent.ImageClient {
 	return helper.EntGetClient().Image
 }
 
+func getImageVersionClient() *ent.ImageVersionClient {
+	return helper.EntGetClient().ImageVersion
+}
+
 func getStorageClient() *ent.StorageClient {
 	return helper.EntGetClient().Storage
 }
 
+func getPresetClient() *ent.PresetClient {
+	return helper.EntGetClient().Preset
+}
+
 func newMagicalCaptchaValidate() elton.Handler {
 	magicValue := ""
 	if !util.IsProduction() {
@@ -17,8 +17,11 @@ package controller
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"image"
-	"io/ioutil"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,15 +31,22 @@ import (
 	"github.com/vicanso/tiny-site/ent"
 	"github.com/vicanso/tiny-site/ent/bucket"
 	entImage "github.com/vicanso/tiny-site/ent/image"
+	"github.com/vicanso/tiny-site/ent/imageversion"
 	"github.com/vicanso/tiny-site/log"
 	"github.com/vicanso/tiny-site/pipeline"
 	"github.com/vicanso/tiny-site/router"
+	"github.com/vicanso/tiny-site/schema"
+	"github.com/vicanso/tiny-site/service"
+	"github.com/vicanso/tiny-site/storage"
 	"github.com/vicanso/tiny-site/util"
 	"github.com/vicanso/tiny-site/validate"
 )
 
 type imageCtrl struct{}
 
+// 响应头中标记是否命中缓存
+const headerXCache = "X-Cache"
+
 type (
 	bucketAddParams struct {
 		// bucket的名称
@@ -45,12 +55,32 @@ type (
 		Owners []string `json:"owners" validate:"required,dive,xUserAccount"`
 		// 描述
 		Description string `json:"description" validate:"required,xImageDescription"`
+		// 是否允许未签名的pipeline，默认为允许
+		AllowUnsigned *bool `json:"allowUnsigned"`
+		// 图片数据保存的storage，为空则保存在数据库中
+		Storage string `json:"storage" validate:"omitempty,xStorageName"`
+		// 图片数据在storage中的bucket，为空则使用bucket的名称
+		StorageBucket string `json:"storageBucket" validate:"omitempty,xImageStorageBucket"`
+		// 保留的历史版本数量，为0表示不限制，默认为10
+		MaxVersions *int `json:"maxVersions" validate:"omitempty,xImageMaxVersions"`
+		// 元数据的处理策略(keep、stripGPS、stripAll)，默认为保留
+		ExifPolicy string `json:"exifPolicy" validate:"omitempty,xImageExifPolicy"`
 	}
 	bucketUpdateParams struct {
 		// 拥有者
 		Owners []string `json:"owners" validate:"omitempty,dive,xUserAccount"`
 		// 描述
 		Description string `json:"description" validate:"omitempty,xImageDescription"`
+		// 是否允许未签名的pipeline
+		AllowUnsigned *bool `json:"allowUnsigned"`
+		// 图片数据保存的storage，调整后仅新上传的图片保存至该storage
+		Storage string `json:"storage" validate:"omitempty,xStorageName"`
+		// 图片数据在storage中的bucket
+		StorageBucket string `json:"storageBucket" validate:"omitempty,xImageStorageBucket"`
+		// 保留的历史版本数量，为0表示不限制
+		MaxVersions *int `json:"maxVersions" validate:"omitempty,xImageMaxVersions"`
+		// 元数据的处理策略，调整后清除该bucket所有图片处理结果的缓存
+		ExifPolicy string `json:"exifPolicy" validate:"omitempty,xImageExifPolicy"`
 	}
 	bucketListParams struct {
 		listParams
@@ -63,6 +93,10 @@ type (
 		Name        string `json:"name" validate:"omitempty,xImageName"`
 		Tags        string `json:"tags" validate:"omitempty,xImageTags"`
 		Description string `json:"description" validate:"omitempty,xImageDescription"`
+		// 图片数据保存的storage，为空则使用bucket的配置
+		Storage string `json:"storage" validate:"omitempty,xStorageName"`
+		// 图片数据在storage中的bucket
+		StorageBucket string `json:"storageBucket" validate:"omitempty,xImageStorageBucket"`
 
 		creator string
 		data    []byte
@@ -73,6 +107,21 @@ type (
 		Bucket string `json:"bucket" validate:"required,xImageBucket"`
 		Tag    string `json:"tag" validate:"omitempty,xImageTag"`
 	}
+	imageRollbackParams struct {
+		Bucket  string `json:"bucket" validate:"required,xImageBucket"`
+		Name    string `json:"name" validate:"required,xImageName"`
+		Version int    `json:"version" validate:"required,xImageVersion"`
+	}
+	// imageNameParams 图片所在bucket与名称的参数
+	imageNameParams struct {
+		Bucket string `json:"bucket" validate:"required,xImageBucket"`
+		Name   string `json:"name" validate:"required,xImageName"`
+	}
+	imageTrashListParams struct {
+		listParams
+
+		Bucket string `json:"bucket" validate:"required,xImageBucket"`
+	}
 	imageGetThumbnailParams struct {
 		Bucket string `json:"bucket" validate:"required,xImageBucket"`
 		// 图片名称
@@ -80,6 +129,21 @@ type (
 		// 缩略图大小
 		ThumbnailSize int `json:"thumbnailSize" validate:"omitempty,xImageThumbnailSize" default:"128"`
 	}
+	imagePathParams struct {
+		Bucket string `json:"bucket" validate:"required,xImageBucket"`
+		// 预设名称或任务列表
+		Ops string `json:"ops" validate:"required,xImagePathOps"`
+		// 图片名称
+		Name string `json:"name" validate:"required,xImageName"`
+		// 扩展名，用于指定输出的图片格式
+		Ext string `json:"ext" validate:"required,xImageExt"`
+	}
+	pipelineSignParams struct {
+		// 任务列表，以|分隔
+		Tasks string `json:"tasks" validate:"required,xPipelineTasks"`
+		// 有效期(秒)，为0表示不过期
+		TTL int `json:"ttl" validate:"omitempty,xPipelineSignTTL"`
+	}
 )
 
 type (
@@ -87,10 +151,17 @@ type (
 		Count   int           `json:"count"`
 		Buckets []*ent.Bucket `json:"buckets"`
 	}
+	imageVersionListResp struct {
+		Versions []*ent.ImageVersion `json:"versions"`
+	}
 	imageListResp struct {
 		Count  int          `json:"count"`
 		Images []*ent.Image `json:"images"`
 	}
+	pipelineSignResp struct {
+		// 签名后的任务列表
+		Tasks string `json:"tasks"`
+	}
 )
 
 func init() {
@@ -124,6 +195,41 @@ func init() {
 		"/v1",
 		ctrl.listImage,
 	)
+	// 替换图片，原有的数据保存为历史版本
+	g.PUT(
+		"/v1/{bucket}/{name}",
+		newTrackerMiddleware(cs.ActionImageReplace),
+		ctrl.replaceImage,
+	)
+	g.GET(
+		"/v1/{bucket}/{name}/versions",
+		ctrl.listImageVersion,
+	)
+	g.POST(
+		"/v1/{bucket}/{name}/versions/{version}/rollback",
+		newTrackerMiddleware(cs.ActionImageRollback),
+		ctrl.rollbackImage,
+	)
+	// 删除的图片移至回收站，保留期限后清除
+	g.DELETE(
+		"/v1/{bucket}/{name}",
+		newTrackerMiddleware(cs.ActionImageDelete),
+		ctrl.deleteImage,
+	)
+	g.GET(
+		"/v1/trash",
+		ctrl.listTrash,
+	)
+	g.POST(
+		"/v1/trash/{bucket}/{name}/restore",
+		newTrackerMiddleware(cs.ActionImageRestore),
+		ctrl.restoreImage,
+	)
+
+	g.POST(
+		"/v1/pipeline/sign",
+		ctrl.signPipeline,
+	)
 
 	ng := router.NewGroup(prefix)
 	ng.GET(
@@ -135,6 +241,13 @@ func init() {
 		ctrl.pipeline,
 	)
 
+	// 路径形式的图片地址：/i/{bucket}/{预设或任务列表}/{name}.{ext}
+	pg := router.NewGroup("/i")
+	pg.GET(
+		"/{bucket}/{ops}/{file}",
+		ctrl.getImageByPath,
+	)
+
 }
 
 func (params *bucketListParams) where(query *ent.BucketQuery) *ent.BucketQuery {
@@ -159,26 +272,100 @@ func (params *bucketListParams) count(ctx context.Context) (int, error) {
 }
 
 func (params *imageAddParams) save(ctx context.Context) (*ent.Image, error) {
-	image, imageType, err := image.Decode(bytes.NewReader(params.data))
+	// 回收站中的图片仍占用名称
+	err := storage.CheckImageNotTrashed(ctx, params.Bucket, params.Name)
+	if err != nil {
+		return nil, err
+	}
+	img, imageType, metadata, err := params.decode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := params.saveBlob(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return getImageClient().Create().
+	create := getImageClient().Create().
 		SetBucket(params.Bucket).
 		SetName(params.Name).
 		SetType(imageType).
-		SetSize(len(params.data)).
-		SetWidth(image.Bounds().Dx()).
-		SetHeight(image.Bounds().Dy()).
+		SetWidth(img.Bounds().Dx()).
+		SetHeight(img.Bounds().Dy()).
+		SetMetadata(metadata).
 		SetTags(params.Tags).
 		SetCreator(params.creator).
-		SetData(params.data).
-		SetDescription(params.Description).
-		Save(ctx)
+		SetDescription(params.Description)
+	storage.SetImageBlob(create.Mutation(), ref, params.data)
+	result, err := create.Save(ctx)
+	if err != nil {
+		storage.DeleteImageBlob(ctx, ref)
+		return nil, err
+	}
+	return result, nil
+}
+
+// decode 解码图片并提取metadata(EXIF、主色调、blurhash)，
+// 按bucket的策略移除元数据后的数据替换原有的数据，返回按EXIF的方向调整后的图像
+func (params *imageAddParams) decode(ctx context.Context) (image.Image, string, *http.Header, error) {
+	// 先校验宽高等限制再解码，避免超大图片耗尽内存
+	img, imageType, err := storage.DecodeImage(params.data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	result := pipeline.ExtractMetadata(params.data, img)
+	policy, err := storage.GetBucketExifPolicy(ctx, params.Bucket)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	err = result.ApplyExifPolicy(imageType, policy)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	params.data = result.Data
+	return result.Image, imageType, &result.Metadata, nil
+}
+
+// saveBlob 保存图片数据，保存在数据库中的返回nil
+func (params *imageAddParams) saveBlob(ctx context.Context) (*storage.ImageBlobRef, error) {
+	if params.Storage == "" {
+		// bucket设置了storage则图片数据保存至storage
+		return storage.SaveImageBlob(ctx, params.Bucket, params.Name, params.data)
+	}
+	// 指定了storage则保存至该storage
+	ref := storage.NewImageBlobRef(params.Storage, params.StorageBucket, params.Bucket, params.Name)
+	err := storage.PutImageBlob(ctx, ref, params.data)
+	if err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// replace 替换图片，原有的数据保存为历史版本
+func (params *imageAddParams) replace(ctx context.Context) (*ent.Image, error) {
+	img, imageType, metadata, err := params.decode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := params.saveBlob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.ReplaceImage(ctx, params.Bucket, params.Name, ref, ent.Image{
+		Type:        imageType,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Metadata:    metadata,
+		Tags:        params.Tags,
+		Description: params.Description,
+		Creator:     params.creator,
+		Data:        params.data,
+	})
 }
 
 func (params *imageListParams) where(query *ent.ImageQuery) *ent.ImageQuery {
+	// 回收站中的图片不返回
+	query.Where(entImage.DeletedAtIsNil())
 	if params.Bucket != "" {
 		query.Where(entImage.Bucket(params.Bucket))
 	}
@@ -195,16 +382,20 @@ func (params *imageListParams) queryAll(ctx context.Context) ([]*ent.Image, erro
 		Order(params.GetOrders()...)
 	params.where(query)
 	fields := params.GetFields()
-	if len(fields) != 0 {
-		result := make([]*ent.Image, 0)
-		err := query.Select(fields...).Scan(ctx, &result)
-		if err != nil {
-			return nil, err
+	// 默认不查询图片数据
+	if len(fields) == 0 {
+		for _, column := range entImage.Columns {
+			if column != entImage.FieldData {
+				fields = append(fields, column)
+			}
 		}
-		return result, nil
 	}
-
-	return query.All(ctx)
+	result := make([]*ent.Image, 0)
+	err := query.Select(fields...).Scan(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 func (params *imageListParams) count(ctx context.Context) (int, error) {
@@ -230,7 +421,34 @@ func (params *bucketUpdateParams) updateOneID(ctx context.Context, id int, creat
 	if len(params.Owners) != 0 {
 		updateOne.SetOwners(params.Owners)
 	}
-	return updateOne.Save(ctx)
+	if params.AllowUnsigned != nil {
+		updateOne.SetAllowUnsigned(*params.AllowUnsigned)
+	}
+	if params.Storage != "" {
+		_, err = storage.GetBlob(params.Storage)
+		if err != nil {
+			return nil, err
+		}
+		updateOne.SetStorage(params.Storage)
+	}
+	if params.StorageBucket != "" {
+		updateOne.SetStorageBucket(params.StorageBucket)
+	}
+	if params.MaxVersions != nil {
+		updateOne.SetMaxVersions(*params.MaxVersions)
+	}
+	if params.ExifPolicy != "" {
+		updateOne.SetExifPolicy(bucket.ExifPolicy(params.ExifPolicy))
+	}
+	updated, err := updateOne.Save(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 已缓存的处理结果仍为原有策略处理的数据
+	if updated.ExifPolicy != result.ExifPolicy {
+		purgeBucketResultCache(ctx, updated.Name)
+	}
+	return updated, nil
 }
 
 func validateBucketForUser(ctx context.Context, bucketName, account string) error {
@@ -244,7 +462,7 @@ func validateBucketForUser(ctx context.Context, bucketName, account string) erro
 		return err
 	}
 	if len(result.Owners) != 0 && !util.ContainsString(result.Owners, account) {
-		return hes.New("无权限添加图片至此bucket")
+		return hes.New("无权限操作此bucket的图片")
 	}
 	return nil
 }
@@ -255,17 +473,31 @@ func (*imageCtrl) addBucket(c *elton.Context) error {
 	if err != nil {
 		return err
 	}
+	// 仅可使用已初始化的storage
+	if params.Storage != "" {
+		_, err = storage.GetBlob(params.Storage)
+		if err != nil {
+			return err
+		}
+	}
 	account := getUserSession(c).MustGetInfo().Account
-	bucket, err := getBucketClient().Create().
+	create := getBucketClient().Create().
 		SetName(params.Name).
 		SetOwners(params.Owners).
 		SetDescription(params.Description).
-		SetCreator(account).
-		Save(c.Context())
+		SetNillableAllowUnsigned(params.AllowUnsigned).
+		SetStorage(params.Storage).
+		SetStorageBucket(params.StorageBucket).
+		SetNillableMaxVersions(params.MaxVersions).
+		SetCreator(account)
+	if params.ExifPolicy != "" {
+		create.SetExifPolicy(bucket.ExifPolicy(params.ExifPolicy))
+	}
+	result, err := create.Save(c.Context())
 	if err != nil {
 		return err
 	}
-	c.Created(bucket)
+	c.Created(result)
 	return nil
 }
 
@@ -315,10 +547,12 @@ func (*imageCtrl) listBucket(c *elton.Context) error {
 
 func (*imageCtrl) addImage(c *elton.Context) error {
 	params := imageAddParams{
-		Bucket: c.Request.FormValue("bucket"),
-		Name:   c.Request.FormValue("name"),
-		Tags:   c.Request.FormValue("tags"),
-		Description: c.Request.FormValue("description"),
+		Bucket:        c.Request.FormValue("bucket"),
+		Name:          c.Request.FormValue("name"),
+		Tags:          c.Request.FormValue("tags"),
+		Description:   c.Request.FormValue("description"),
+		Storage:       c.Request.FormValue("storage"),
+		StorageBucket: c.Request.FormValue("storageBucket"),
 	}
 	err := validate.Struct(&params)
 	if err != nil {
@@ -338,7 +572,7 @@ func (*imageCtrl) addImage(c *elton.Context) error {
 		return err
 	}
 	defer file.Close()
-	buf, err := ioutil.ReadAll(file)
+	buf, err := storage.ReadImageData(file)
 	if err != nil {
 		return err
 	}
@@ -354,6 +588,92 @@ func (*imageCtrl) addImage(c *elton.Context) error {
 	return nil
 }
 
+// replaceImage 替换图片，原有的数据保存为历史版本并清除其处理结果的缓存
+func (*imageCtrl) replaceImage(c *elton.Context) error {
+	params := imageAddParams{
+		Bucket:        c.Param("bucket"),
+		Name:          c.Param("name"),
+		Tags:          c.Request.FormValue("tags"),
+		Description:   c.Request.FormValue("description"),
+		Storage:       c.Request.FormValue("storage"),
+		StorageBucket: c.Request.FormValue("storageBucket"),
+	}
+	err := validate.Struct(&params)
+	if err != nil {
+		return err
+	}
+	ctx := c.Context()
+	account := getUserSession(c).MustGetInfo().Account
+	err = validateBucketForUser(ctx, params.Bucket, account)
+	if err != nil {
+		return err
+	}
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	buf, err := storage.ReadImageData(file)
+	if err != nil {
+		return err
+	}
+	params.creator = account
+	params.data = buf
+	result, err := params.replace(ctx)
+	if err != nil {
+		return err
+	}
+	purgeImageResultCache(ctx, params.Bucket, params.Name)
+	// 图片数据不返回
+	result.Data = nil
+	c.Body = result
+	return nil
+}
+
+// listImageVersion 获取图片的历史版本
+func (*imageCtrl) listImageVersion(c *elton.Context) error {
+	params, _, err := getImageNameParams(c)
+	if err != nil {
+		return err
+	}
+	versions, err := storage.ListImageVersions(c.Context(), params.Bucket, params.Name)
+	if err != nil {
+		return err
+	}
+	c.Body = &imageVersionListResp{
+		Versions: versions,
+	}
+	return nil
+}
+
+// rollbackImage 回滚图片至指定版本
+func (*imageCtrl) rollbackImage(c *elton.Context) error {
+	version, _ := strconv.Atoi(c.Param("version"))
+	params := imageRollbackParams{
+		Bucket:  c.Param("bucket"),
+		Name:    c.Param("name"),
+		Version: version,
+	}
+	err := validate.Struct(&params)
+	if err != nil {
+		return err
+	}
+	ctx := c.Context()
+	account := getUserSession(c).MustGetInfo().Account
+	err = validateBucketForUser(ctx, params.Bucket, account)
+	if err != nil {
+		return err
+	}
+	result, err := storage.RollbackImage(ctx, params.Bucket, params.Name, params.Version, account)
+	if err != nil {
+		return err
+	}
+	purgeImageResultCache(ctx, params.Bucket, params.Name)
+	result.Data = nil
+	c.Body = result
+	return nil
+}
+
 func (*imageCtrl) listImage(c *elton.Context) error {
 	params := imageListParams{}
 	err := validateQuery(c, &params)
@@ -386,11 +706,19 @@ func (*imageCtrl) getImageThumbnail(c *elton.Context) error {
 	if err != nil {
 		return err
 	}
+	ctx := c.Context()
+	// 缩略图无需登录，与未签名的pipeline一样仅允许访问允许未签名的bucket
+	err = validateUnsignedPipeline(ctx, []string{
+		"bucket/" + params.Bucket + "/" + params.Name,
+	})
+	if err != nil {
+		return err
+	}
 	jobs := []pipeline.ImageJob{
 		pipeline.NewGetEntImage(params.Bucket, params.Name),
 		pipeline.NewFitResizeImage(params.ThumbnailSize, params.ThumbnailSize),
 	}
-	img, err := pipeline.Do(c.Context(), nil, jobs...)
+	img, err := pipeline.Do(ctx, nil, jobs...)
 	if err != nil {
 		return err
 	}
@@ -401,27 +729,458 @@ func (*imageCtrl) getImageThumbnail(c *elton.Context) error {
 	return nil
 }
 
+// getPipelineBuckets 获取任务列表中引用的bucket，本地文件的storage按使用该storage的bucket校验权限，
+// 无bucket使用的storage则与外部的图片一样必须签名
+func getPipelineBuckets(ctx context.Context, tasks []string) ([]string, bool, error) {
+	buckets, ok := pipeline.GetTaskBuckets(tasks)
+	for _, name := range pipeline.GetTaskFileStorages(tasks) {
+		names, err := getBucketClient().Query().
+			Where(bucket.Storage(name)).
+			Select(bucket.FieldName).
+			Strings(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(names) == 0 {
+			ok = false
+			continue
+		}
+		buckets = append(buckets, names...)
+	}
+	return buckets, ok, nil
+}
+
+// validateUnsignedPipeline 校验未签名的pipeline，
+// 仅允许访问允许未签名的bucket
+func validateUnsignedPipeline(ctx context.Context, tasks []string) error {
+	buckets, ok, err := getPipelineBuckets(ctx, tasks)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return pipeline.ErrSignatureRequired
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	count, err := getBucketClient().Query().
+		Where(
+			bucket.NameIn(buckets...),
+			bucket.AllowUnsigned(false),
+		).
+		Count(ctx)
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return pipeline.ErrSignatureRequired
+	}
+	return nil
+}
+
+func (*imageCtrl) signPipeline(c *elton.Context) error {
+	params := pipelineSignParams{}
+	err := validateBody(c, &params)
+	if err != nil {
+		return err
+	}
+	rawTasks := strings.Split(params.Tasks, "|")
+	ctx := c.Context()
+	// 预设中引用的图片也需校验
+	expandedTasks, err := pipeline.ExpandPresets(ctx, rawTasks)
+	if err != nil {
+		return err
+	}
+	info := getUserSession(c).MustGetInfo()
+	buckets, ok, err := getPipelineBuckets(ctx, expandedTasks)
+	if err != nil {
+		return err
+	}
+	// 外部的图片(proxy、其它storage)以及保存至storage的仅允许管理员签名
+	if !ok && !util.ContainsAny([]string{schema.UserRoleSu, schema.UserRoleAdmin}, info.Roles) {
+		return hes.NewWithStatusCode("only admin can sign pipeline with external source or save task", http.StatusForbidden)
+	}
+	// 仅可签名有权限的bucket的图片
+	checked := make(map[string]bool)
+	for _, name := range buckets {
+		if checked[name] {
+			continue
+		}
+		checked[name] = true
+		err = validateBucketForUser(ctx, name, info.Account)
+		if err != nil {
+			return err
+		}
+	}
+	tasks, err := pipeline.Sign(
+		rawTasks,
+		service.GetSignedKeys().GetKeys(),
+		time.Duration(params.TTL)*time.Second,
+	)
+	if err != nil {
+		return err
+	}
+	c.Body = &pipelineSignResp{
+		Tasks: strings.Join(tasks, "|"),
+	}
+	return nil
+}
+
 func (*imageCtrl) pipeline(c *elton.Context) error {
 	rawQuery := c.Request.URL.RawQuery
 	if len(rawQuery) == 0 {
 		return hes.New("pipeline can not be empty")
 	}
-	tasks := strings.Split(rawQuery, "|")
+	tasks, signature, err := pipeline.SplitSignature(pipeline.NormalizeTasks(strings.Split(rawQuery, "|")))
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return hes.New("pipeline can not be empty")
+	}
+	ctx := c.Context()
+	// 签名针对原始的任务列表，因此预设调整后签名仍有效
+	if signature != nil {
+		err = pipeline.Verify(tasks, signature, service.GetSignedKeys().GetKeys())
+		if err != nil {
+			return err
+		}
+	}
+	expandedTasks, err := pipeline.ExpandPresets(ctx, tasks)
+	if err != nil {
+		return err
+	}
+	// 校验后才可使用缓存
+	if signature == nil {
+		err = validateUnsignedPipeline(ctx, expandedTasks)
+	} else {
+		err = validateExpandedPipeline(ctx, tasks, expandedTasks)
+	}
+	if err != nil {
+		return err
+	}
+	return doPipeline(c, expandedTasks)
+}
+
+// validateExpandedPipeline 校验已签名的pipeline展开预设后的任务列表，
+// 签名未包括预设的任务，因此展开后引用的图片不可超出签名时的范围
+func validateExpandedPipeline(ctx context.Context, tasks, expandedTasks []string) error {
+	signedBuckets, signedOK, err := getPipelineBuckets(ctx, tasks)
+	if err != nil {
+		return err
+	}
+	buckets, ok, err := getPipelineBuckets(ctx, expandedTasks)
+	if err != nil {
+		return err
+	}
+	if signedOK && !ok {
+		return pipeline.ErrSignatureInvalid
+	}
+	for _, name := range buckets {
+		if !util.ContainsString(signedBuckets, name) {
+			return pipeline.ErrSignatureInvalid
+		}
+	}
+	return nil
+}
+
+// doPipeline 执行已校验的任务列表，优先使用缓存的处理结果
+func doPipeline(c *elton.Context, tasks []string) error {
+	ctx := c.Context()
+	// 根据Accept选择格式的，响应需要根据Accept区分
+	if pipeline.IsAcceptNegotiated(tasks) {
+		c.SetHeader("Vary", "Accept")
+	}
+	// 有保存任务的每次均需执行，不使用缓存
+	cacheable := !pipeline.HasSaveTask(tasks)
+	cacheKey := pipeline.GetResultCacheKey(tasks, c.Request.Header)
+	var img *storage.Image
+	var err error
+	if cacheable {
+		img, err = pipeline.GetResultCache(ctx, cacheKey)
+	}
+	// 获取缓存失败则重新处理
+	if err != nil {
+		log.Error(ctx).
+			Err(err).
+			Msg("get pipeline cache fail")
+	}
+	if img != nil {
+		c.SetHeader(headerXCache, "hit")
+		c.SetContentTypeByExt("." + img.Type)
+		c.BodyBuffer = bytes.NewBuffer(img.Data)
+		return nil
+	}
+
 	jobs, err := pipeline.Parse(tasks, c.Request.Header)
 	if err != nil {
 		return err
 	}
-	img, err := pipeline.Do(c.Context(), nil, jobs...)
+	img, err = pipeline.Do(ctx, nil, jobs...)
 	if err != nil {
 		return err
 	}
-	log.Info(c.Context()).
+	percent := 0
+	if img.OriginalSize > 0 {
+		percent = 100 * img.Size / img.OriginalSize
+	}
+	log.Info(ctx).
 		Strs("tasks", tasks).
 		Int("originalSize", img.OriginalSize).
 		Int("size", img.Size).
-		Int("percent", 100*img.Size/img.OriginalSize).
+		Int("percent", percent).
 		Msg("")
+	if cacheable {
+		err = pipeline.SetResultCache(ctx, cacheKey, tasks, img)
+	}
+	if err != nil {
+		log.Error(ctx).
+			Err(err).
+			Msg("set pipeline cache fail")
+	}
+	c.SetHeader(headerXCache, "miss")
 	c.SetContentTypeByExt("." + img.Type)
 	c.BodyBuffer = bytes.NewBuffer(img.Data)
 	return nil
 }
+
+// isImageFresh 判断客户端缓存是否仍有效
+func isImageFresh(header http.Header, eTag string, lastModified time.Time) bool {
+	noneMatch := header.Get("If-None-Match")
+	if noneMatch != "" {
+		for _, value := range strings.Split(noneMatch, ",") {
+			value = strings.TrimSpace(value)
+			if value == "*" || value == eTag {
+				return true
+			}
+		}
+		return false
+	}
+	modifiedSince, err := http.ParseTime(header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(modifiedSince)
+}
+
+// newImageETag 预设调整后任务列表不同，因此eTag根据更新时间、版本与任务列表生成
+func newImageETag(updatedAt time.Time, version int, tasks []string) string {
+	hash := sha1.Sum([]byte(strconv.FormatInt(updatedAt.UnixNano(), 10) + "|" + strconv.Itoa(version) + "|" + strings.Join(tasks, "|")))
+	return `W/"` + hex.EncodeToString(hash[:]) + `"`
+}
+
+// getImageUpdatedAt 获取图片的更新时间与版本号，
+// 指定了非当前的版本则返回该历史版本的更新时间
+func getImageUpdatedAt(ctx context.Context, bucketName, name string, version int) (time.Time, int, error) {
+	result := make([]*ent.Image, 0)
+	err := getImageClient().Query().
+		Where(
+			entImage.Bucket(bucketName),
+			entImage.Name(name),
+			entImage.DeletedAtIsNil(),
+		).
+		Select(entImage.FieldUpdatedAt, entImage.FieldVersion).
+		Scan(ctx, &result)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if len(result) == 0 {
+		return time.Time{}, 0, storage.ErrImageNotFound
+	}
+	current := result[0]
+	if version == 0 || version == current.Version {
+		return current.UpdatedAt, current.Version, nil
+	}
+	versions := make([]*ent.ImageVersion, 0)
+	err = getImageVersionClient().Query().
+		Where(
+			imageversion.Bucket(bucketName),
+			imageversion.Name(name),
+			imageversion.Version(version),
+		).
+		Select(imageversion.FieldUpdatedAt).
+		Scan(ctx, &versions)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if len(versions) == 0 {
+		return time.Time{}, 0, storage.ErrImageVersionNotFound
+	}
+	return versions[0].UpdatedAt, version, nil
+}
+
+func (*imageCtrl) getImageByPath(c *elton.Context) error {
+	file := c.Param("file")
+	index := strings.LastIndex(file, ".")
+	if index <= 0 {
+		return hes.New("extension of image can not be empty")
+	}
+	// 可通过name@v3指定版本
+	name, version, err := storage.ParseImageVersion(file[:index])
+	if err != nil {
+		return err
+	}
+	params := imagePathParams{
+		Bucket: c.Param("bucket"),
+		Ops:    c.Param("ops"),
+		Name:   name,
+		Ext:    file[index+1:],
+	}
+	err = validate.Struct(&params)
+	if err != nil {
+		return err
+	}
+	ctx := c.Context()
+	tasks, err := pipeline.ParsePathTasks(ctx, params.Bucket, params.Ops, file[:index], params.Ext)
+	if err != nil {
+		return err
+	}
+	// 路径形式不支持签名，需要签名的bucket使用pipeline
+	err = validateUnsignedPipeline(ctx, tasks)
+	if err != nil {
+		return err
+	}
+
+	updatedAt, version, err := getImageUpdatedAt(ctx, params.Bucket, params.Name, version)
+	if err != nil {
+		return err
+	}
+	eTag := newImageETag(updatedAt, version, tasks)
+	c.SetHeader("ETag", eTag)
+	c.SetHeader("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+	c.CacheMaxAge(10 * time.Minute)
+	if isImageFresh(c.Request.Header, eTag, updatedAt) {
+		c.NotModified()
+		return nil
+	}
+	return doPipeline(c, tasks)
+}
+
+// purgeImageResultCache 清除图片处理结果的缓存，失败时只输出日志
+func purgeImageResultCache(ctx context.Context, bucket, name string) {
+	_, err := pipeline.PurgeResultCache(ctx, bucket, name)
+	if err != nil {
+		log.Error(ctx).
+			Str("bucket", bucket).
+			Str("name", name).
+			Err(err).
+			Msg("purge pipeline cache fail")
+	}
+}
+
+// purgeBucketResultCache 清除bucket中所有图片处理结果的缓存，失败时只输出日志
+func purgeBucketResultCache(ctx context.Context, bucketName string) {
+	names, err := getImageClient().Query().
+		Where(entImage.Bucket(bucketName)).
+		Select(entImage.FieldName).
+		Strings(ctx)
+	if err != nil {
+		log.Error(ctx).
+			Str("bucket", bucketName).
+			Err(err).
+			Msg("query images of bucket fail")
+		return
+	}
+	for _, name := range names {
+		purgeImageResultCache(ctx, bucketName, name)
+	}
+}
+
+// getImageNameParams 获取路由中图片所在bucket与名称的参数，并校验用户是否有该bucket的权限
+func getImageNameParams(c *elton.Context) (*imageNameParams, string, error) {
+	params := &imageNameParams{
+		Bucket: c.Param("bucket"),
+		Name:   c.Param("name"),
+	}
+	err := validate.Struct(params)
+	if err != nil {
+		return nil, "", err
+	}
+	account := getUserSession(c).MustGetInfo().Account
+	err = validateBucketForUser(c.Context(), params.Bucket, account)
+	if err != nil {
+		return nil, "", err
+	}
+	return params, account, nil
+}
+
+// deleteImage 删除图片，图片移至回收站并清除其处理结果的缓存
+func (*imageCtrl) deleteImage(c *elton.Context) error {
+	params, account, err := getImageNameParams(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Context()
+	err = storage.TrashImage(ctx, params.Bucket, params.Name, account)
+	if err != nil {
+		return err
+	}
+	purgeImageResultCache(ctx, params.Bucket, params.Name)
+	c.NoContent()
+	return nil
+}
+
+// listTrash 获取回收站中的图片
+func (*imageCtrl) listTrash(c *elton.Context) error {
+	params := imageTrashListParams{
+		listParams: listParams{
+			Order: "-deletedAt",
+		},
+	}
+	err := validateQuery(c, &params)
+	if err != nil {
+		return err
+	}
+	ctx := c.Context()
+	err = validateBucketForUser(ctx, params.Bucket, getUserSession(c).MustGetInfo().Account)
+	if err != nil {
+		return err
+	}
+	query := getImageClient().Query().
+		Where(
+			entImage.Bucket(params.Bucket),
+			entImage.DeletedAtNotNil(),
+		)
+	count := -1
+	if params.ShouldCount() {
+		count, err = query.Clone().Count(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	// 不查询图片数据
+	fields := make([]string, 0, len(entImage.Columns))
+	for _, column := range entImage.Columns {
+		if column != entImage.FieldData {
+			fields = append(fields, column)
+		}
+	}
+	images := make([]*ent.Image, 0)
+	err = query.Limit(params.GetLimit()).
+		Offset(params.GetOffset()).
+		Order(params.GetOrders()...).
+		Select(fields...).
+		Scan(ctx, &images)
+	if err != nil {
+		return err
+	}
+	c.Body = &imageListResp{
+		Count:  count,
+		Images: images,
+	}
+	return nil
+}
+
+// restoreImage 从回收站中恢复图片
+func (*imageCtrl) restoreImage(c *elton.Context) error {
+	params, _, err := getImageNameParams(c)
+	if err != nil {
+		return err
+	}
+	err = storage.RestoreImage(c.Context(), params.Bucket, params.Name)
+	if err != nil {
+		return err
+	}
+	c.NoContent()
+	return nil
+}
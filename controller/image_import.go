@@ -0,0 +1,335 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/config"
+	"github.com/vicanso/tiny-site/cs"
+	"github.com/vicanso/tiny-site/ent"
+	entImage "github.com/vicanso/tiny-site/ent/image"
+	"github.com/vicanso/tiny-site/log"
+	"github.com/vicanso/tiny-site/router"
+	"github.com/vicanso/tiny-site/service"
+	"github.com/vicanso/tiny-site/storage"
+	"github.com/vicanso/tiny-site/validate"
+)
+
+var imageImportConfig = config.MustGetImageImportConfig()
+
+// 异步任务每处理的文件数量更新一次进度
+const imageImportProgressInterval = 20
+
+type (
+	imageImportReader interface {
+		io.Reader
+		io.ReaderAt
+		io.Closer
+	}
+	// imageImportFile 上传的文件(图片或压缩包)
+	imageImportFile struct {
+		filename string
+		size     int64
+		open     func() (imageImportReader, error)
+	}
+	// imageImporter 将上传的文件逐个保存为图片
+	imageImporter struct {
+		// 公共的参数，名称由文件路径生成
+		params imageAddParams
+		job    *service.ImageImportJob
+	}
+)
+
+func init() {
+	g := router.NewGroup("/images", loadUserSession, shouldBeLogin)
+	ctrl := imageCtrl{}
+
+	// 批量上传图片或导入压缩包(zip、tar)，较大的压缩包以异步任务处理
+	g.POST(
+		"/v1/imports",
+		newTrackerMiddleware(cs.ActionImageImport),
+		ctrl.importImage,
+	)
+	// 查询异步导入任务的进度
+	g.GET(
+		"/v1/imports/{id}",
+		ctrl.getImageImportJob,
+	)
+}
+
+// newImageImportFiles 转换上传的文件，返回文件列表、文件总数(压缩包中的文件单独计算)以及压缩包的总长度
+func newImageImportFiles(headers []*multipart.FileHeader) ([]*imageImportFile, int, int64, error) {
+	files := make([]*imageImportFile, 0, len(headers))
+	total := 0
+	var archiveSize int64
+	for _, item := range headers {
+		header := item
+		file := &imageImportFile{
+			filename: header.Filename,
+			size:     header.Size,
+			open: func() (imageImportReader, error) {
+				return header.Open()
+			},
+		}
+		files = append(files, file)
+		if !storage.IsArchive(file.filename) {
+			total++
+			continue
+		}
+		if file.size > int64(imageImportConfig.MaxArchiveSize) {
+			return nil, 0, 0, hes.NewWithStatusCode(fmt.Sprintf("size of archive should be <= %d bytes", imageImportConfig.MaxArchiveSize), http.StatusRequestEntityTooLarge)
+		}
+		archiveSize += file.size
+		count, err := file.count()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		total += count
+	}
+	if total > imageImportConfig.MaxFiles {
+		return nil, 0, 0, hes.New(fmt.Sprintf("count of files should be <= %d", imageImportConfig.MaxFiles))
+	}
+	return files, total, archiveSize, nil
+}
+
+// copyImageImportFiles 上传的临时文件在请求结束后删除，因此异步处理时需先复制
+func copyImageImportFiles(files []*imageImportFile) (string, []*imageImportFile, error) {
+	dir, err := os.MkdirTemp("", "image-import-")
+	if err != nil {
+		return "", nil, err
+	}
+	result := make([]*imageImportFile, len(files))
+	for index, file := range files {
+		tmpFile := filepath.Join(dir, fmt.Sprintf("%d-%s", index, filepath.Base(file.filename)))
+		err = file.copyTo(tmpFile)
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			return "", nil, err
+		}
+		result[index] = &imageImportFile{
+			filename: file.filename,
+			size:     file.size,
+			open: func() (imageImportReader, error) {
+				return os.Open(tmpFile)
+			},
+		}
+	}
+	return dir, result, nil
+}
+
+func (file *imageImportFile) count() (int, error) {
+	r, err := file.open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return storage.CountArchiveFiles(file.filename, r, file.size)
+}
+
+func (file *imageImportFile) copyTo(filename string) error {
+	r, err := file.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// run 逐个导入文件，单个文件失败时记录原因，压缩包无法读取则任务失败
+func (importer *imageImporter) run(ctx context.Context, files []*imageImportFile) {
+	var err error
+	for _, file := range files {
+		err = importer.importFile(ctx, file)
+		if err != nil {
+			break
+		}
+	}
+	importer.job.Done(err)
+	importer.saveJob(ctx)
+}
+
+func (importer *imageImporter) importFile(ctx context.Context, file *imageImportFile) error {
+	r, err := file.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if !storage.IsArchive(file.filename) {
+		data, err := storage.ReadImageData(r)
+		importer.add(ctx, file.filename, data, err)
+		return nil
+	}
+	return storage.WalkArchive(file.filename, r, file.size, func(item storage.ArchiveFile) error {
+		importer.add(ctx, item.Path, item.Data, item.Err)
+		return nil
+	})
+}
+
+func (importer *imageImporter) add(ctx context.Context, file string, data []byte, err error) {
+	job := importer.job
+	job.Add(importer.save(ctx, file, data, err))
+	if job.Processed%imageImportProgressInterval == 0 {
+		importer.saveJob(ctx)
+	}
+}
+
+// save 保存图片，已存在同名的图片则跳过
+func (importer *imageImporter) save(ctx context.Context, file string, data []byte, err error) service.ImageImportResult {
+	result := service.ImageImportResult{
+		File:   file,
+		Name:   storage.ImageNameFromPath(file),
+		Result: service.ImageImportRejected,
+	}
+	if err != nil {
+		result.Reason = hes.Wrap(err).Message
+		return result
+	}
+	params := importer.params
+	params.Name = result.Name
+	params.data = data
+	err = validate.Struct(&params)
+	if err != nil {
+		result.Reason = hes.Wrap(err).Message
+		return result
+	}
+	// 回收站中的图片也视为已存在
+	exists, err := getImageClient().Query().
+		Where(
+			entImage.Bucket(params.Bucket),
+			entImage.Name(params.Name),
+		).
+		Exist(ctx)
+	if err == nil && !exists {
+		_, err = params.save(ctx)
+		if ent.IsConstraintError(err) {
+			exists = true
+		}
+	}
+	switch {
+	case exists:
+		result.Result = service.ImageImportSkipped
+		result.Reason = "image already exists"
+	case err != nil:
+		result.Reason = hes.Wrap(err).Message
+	default:
+		result.Result = service.ImageImportCreated
+	}
+	return result
+}
+
+// saveJob 保存异步任务的进度，同步处理的无需保存
+func (importer *imageImporter) saveJob(ctx context.Context) {
+	if importer.job.ID == "" {
+		return
+	}
+	err := importer.job.Save(ctx, imageImportConfig.JobTTL)
+	if err != nil {
+		log.Error(ctx).
+			Str("id", importer.job.ID).
+			Err(err).
+			Msg("save image import job fail")
+	}
+}
+
+// importImage 批量导入图片，文件字段可为多个图片或压缩包，图片名称由文件路径生成
+func (*imageCtrl) importImage(c *elton.Context) error {
+	params := imageAddParams{
+		Bucket:        c.Request.FormValue("bucket"),
+		Tags:          c.Request.FormValue("tags"),
+		Description:   c.Request.FormValue("description"),
+		Storage:       c.Request.FormValue("storage"),
+		StorageBucket: c.Request.FormValue("storageBucket"),
+	}
+	err := validate.Struct(&params)
+	if err != nil {
+		return err
+	}
+	ctx := c.Context()
+	account := getUserSession(c).MustGetInfo().Account
+	err = validateBucketForUser(ctx, params.Bucket, account)
+	if err != nil {
+		return err
+	}
+	var headers []*multipart.FileHeader
+	if c.Request.MultipartForm != nil {
+		headers = c.Request.MultipartForm.File["file"]
+	}
+	if len(headers) == 0 {
+		return hes.New("file can not be empty")
+	}
+	files, total, archiveSize, err := newImageImportFiles(headers)
+	if err != nil {
+		return err
+	}
+	params.creator = account
+	async := archiveSize > int64(imageImportConfig.AsyncSize)
+	importer := &imageImporter{
+		params: params,
+		job:    service.NewImageImportJob(params.Bucket, account, total, async),
+	}
+	if !async {
+		importer.run(ctx, files)
+		c.Body = importer.job
+		return nil
+	}
+
+	dir, files, err := copyImageImportFiles(files)
+	if err != nil {
+		return err
+	}
+	err = importer.job.Save(ctx, imageImportConfig.JobTTL)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	go func() {
+		defer os.RemoveAll(dir)
+		importer.run(context.Background(), files)
+	}()
+	c.StatusCode = http.StatusAccepted
+	c.Body = importer.job
+	return nil
+}
+
+// getImageImportJob 获取异步导入任务的进度，仅创建者可查询
+func (*imageCtrl) getImageImportJob(c *elton.Context) error {
+	job, err := service.GetImageImportJob(c.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if job.Creator != getUserSession(c).MustGetInfo().Account {
+		return service.ErrImageImportJobNotFound
+	}
+	c.Body = job
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsImageFresh(t *testing.T) {
+	assert := assert.New(t)
+
+	eTag := `W/"abc"`
+	lastModified := time.Date(2022, 1, 1, 0, 0, 0, 500, time.UTC)
+
+	header := http.Header{}
+	assert.False(isImageFresh(header, eTag, lastModified))
+
+	header.Set("If-None-Match", `W/"123", W/"abc"`)
+	assert.True(isImageFresh(header, eTag, lastModified))
+	header.Set("If-None-Match", `W/"123"`)
+	assert.False(isImageFresh(header, eTag, lastModified))
+
+	// If-None-Match优先于If-Modified-Since
+	header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+	assert.False(isImageFresh(header, eTag, lastModified))
+
+	header.Del("If-None-Match")
+	assert.True(isImageFresh(header, eTag, lastModified))
+	assert.False(isImageFresh(header, eTag, lastModified.Add(time.Second)))
+}
+
+func TestNewImageETag(t *testing.T) {
+	assert := assert.New(t)
+
+	updatedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tasks := []string{"bucket/test/a.png", "optim/png/80"}
+	eTag := newImageETag(updatedAt, 1, tasks)
+	assert.Equal(eTag, newImageETag(updatedAt, 1, tasks))
+
+	// 版本、更新时间与任务不同则eTag不同
+	assert.NotEqual(eTag, newImageETag(updatedAt, 2, tasks))
+	assert.NotEqual(eTag, newImageETag(updatedAt.Add(time.Second), 1, tasks))
+	assert.NotEqual(eTag, newImageETag(updatedAt, 1, tasks[:1]))
+}
@@ -0,0 +1,262 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/cs"
+	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/preset"
+	"github.com/vicanso/tiny-site/helper"
+	"github.com/vicanso/tiny-site/pipeline"
+	"github.com/vicanso/tiny-site/router"
+	"github.com/vicanso/tiny-site/validate"
+)
+
+type presetCtrl struct{}
+
+type (
+	presetAddParams struct {
+		Bucket      string `json:"bucket" validate:"required,xImageBucket"`
+		Name        string `json:"name" validate:"required,xPresetName"`
+		Tasks       string `json:"tasks" validate:"required,xPresetTasks"`
+		Description string `json:"description" validate:"omitempty,xPresetDescription"`
+	}
+	presetUpdateParams struct {
+		Tasks       string `json:"tasks" validate:"omitempty,xPresetTasks"`
+		Description string `json:"description" validate:"omitempty,xPresetDescription"`
+	}
+	presetListParams struct {
+		Bucket string `json:"bucket" validate:"required,xImageBucket"`
+	}
+	presetDeleteParams struct {
+		Bucket string `json:"bucket" validate:"required,xImageBucket"`
+		Name   string `json:"name" validate:"required,xPresetName"`
+	}
+)
+
+type (
+	presetListResp struct {
+		Presets []*ent.Preset `json:"presets"`
+	}
+)
+
+func init() {
+	prefix := "/presets"
+
+	g := router.NewGroup(prefix, loadUserSession, shouldBeLogin)
+	ctrl := presetCtrl{}
+
+	g.GET(
+		"/v1",
+		ctrl.list,
+	)
+
+	g.POST(
+		"/v1",
+		newTrackerMiddleware(cs.ActionPresetAdd),
+		ctrl.add,
+	)
+	g.PATCH(
+		"/v1/{id}",
+		newTrackerMiddleware(cs.ActionPresetUpdate),
+		ctrl.update,
+	)
+	g.GET(
+		"/v1/{id}",
+		ctrl.findByID,
+	)
+	g.DELETE(
+		"/v1/{bucket}/{name}",
+		newTrackerMiddleware(cs.ActionPresetDelete),
+		ctrl.delete,
+	)
+}
+
+// normalizePresetTasks 规范化预设的任务列表并校验
+func normalizePresetTasks(bucket, tasks string) (string, error) {
+	arr := pipeline.NormalizeTasks(strings.Split(tasks, "|"))
+	err := pipeline.ValidatePresetTasks(bucket, arr)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(arr, "|"), nil
+}
+
+func (params *presetAddParams) save(ctx context.Context, creator string) (*ent.Preset, error) {
+	tasks, err := normalizePresetTasks(params.Bucket, params.Tasks)
+	if err != nil {
+		return nil, err
+	}
+	return getPresetClient().Create().
+		SetBucket(params.Bucket).
+		SetName(params.Name).
+		SetTasks(tasks).
+		SetCreator(creator).
+		SetDescription(params.Description).
+		Save(ctx)
+}
+
+func (params *presetUpdateParams) update(ctx context.Context, id int, account string) (*ent.Preset, error) {
+	result, err := getPresetClient().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	err = validateBucketForUser(ctx, result.Bucket, account)
+	if err != nil {
+		return nil, err
+	}
+	update := getPresetClient().UpdateOneID(id)
+	if params.Tasks != "" {
+		tasks, err := normalizePresetTasks(result.Bucket, params.Tasks)
+		if err != nil {
+			return nil, err
+		}
+		update.SetTasks(tasks)
+	}
+	if params.Description != "" {
+		update.SetDescription(params.Description)
+	}
+	result, err = update.Save(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.RemovePresetCache(result.Bucket, result.Name)
+	return result, nil
+}
+
+func (*presetCtrl) add(c *elton.Context) error {
+	params := presetAddParams{}
+	err := validateBody(c, &params)
+	if err != nil {
+		return err
+	}
+	account := getUserSession(c).MustGetInfo().Account
+	err = validateBucketForUser(c.Context(), params.Bucket, account)
+	if err != nil {
+		return err
+	}
+
+	result, err := params.save(c.Context(), account)
+	if err != nil {
+		return err
+	}
+	c.Created(result)
+	return nil
+}
+
+func (*presetCtrl) list(c *elton.Context) error {
+	params := presetListParams{}
+	err := validateQuery(c, &params)
+	if err != nil {
+		return err
+	}
+	ctx := c.Context()
+	account := getUserSession(c).MustGetInfo().Account
+	err = validateBucketForUser(ctx, params.Bucket, account)
+	if err != nil {
+		return err
+	}
+	listParams := listParams{
+		Order: "-updatedAt",
+	}
+	presets, err := getPresetClient().Query().
+		Where(preset.Bucket(params.Bucket)).
+		Order(listParams.GetOrders()...).
+		All(ctx)
+	if err != nil {
+		return err
+	}
+	c.Body = &presetListResp{
+		Presets: presets,
+	}
+	return nil
+}
+
+func (*presetCtrl) update(c *elton.Context) error {
+	params := presetUpdateParams{}
+	err := validateBody(c, &params)
+	if err != nil {
+		return err
+	}
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return err
+	}
+	account := getUserSession(c).MustGetInfo().Account
+	result, err := params.update(c.Context(), id, account)
+	if err != nil {
+		return err
+	}
+	c.Body = result
+	return nil
+}
+
+func (*presetCtrl) findByID(c *elton.Context) error {
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Context()
+	result, err := getPresetClient().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	account := getUserSession(c).MustGetInfo().Account
+	err = validateBucketForUser(ctx, result.Bucket, account)
+	if err != nil {
+		return err
+	}
+	c.Body = result
+	return nil
+}
+
+// delete 删除预设，引用该预设的pipeline将无法使用
+func (*presetCtrl) delete(c *elton.Context) error {
+	params := presetDeleteParams{
+		Bucket: c.Param("bucket"),
+		Name:   c.Param("name"),
+	}
+	err := validate.Struct(&params)
+	if err != nil {
+		return err
+	}
+	ctx := c.Context()
+	account := getUserSession(c).MustGetInfo().Account
+	err = validateBucketForUser(ctx, params.Bucket, account)
+	if err != nil {
+		return err
+	}
+	count, err := getPresetClient().Delete().
+		Where(
+			preset.Bucket(params.Bucket),
+			preset.Name(params.Name),
+		).
+		Exec(helper.EntAllowDelete(ctx, "delete preset by "+account))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return hes.NewWithStatusCode("preset is not found", http.StatusNotFound)
+	}
+	pipeline.RemovePresetCache(params.Bucket, params.Name)
+	c.NoContent()
+	return nil
+}
@@ -18,32 +18,47 @@ import (
 	"context"
 
 	"github.com/vicanso/elton"
+	"github.com/vicanso/hes"
 	"github.com/vicanso/tiny-site/cs"
 	"github.com/vicanso/tiny-site/ent"
-	"github.com/vicanso/tiny-site/ent/storage"
+	entStorage "github.com/vicanso/tiny-site/ent/storage"
+	"github.com/vicanso/tiny-site/helper"
 	"github.com/vicanso/tiny-site/router"
+	"github.com/vicanso/tiny-site/schema"
+	"github.com/vicanso/tiny-site/storage"
 )
 
 type storageCtrl struct{}
 
 type (
 	storageAddParams struct {
-		Name        string `json:"name" validate:"required,xStorageName"`
-		Category    string `json:"category" validate:"required,xStorageCategory"`
-		URI         string `json:"uri" validate:"required,xStorageURI"`
-		Description string `json:"description" validate:"required,xStorageDescription"`
+		Name        string        `json:"name" validate:"required,xStorageName"`
+		Category    string        `json:"category" validate:"required,xStorageCategory"`
+		URI         string        `json:"uri" validate:"required,xStorageURI"`
+		Description string        `json:"description" validate:"required,xStorageDescription"`
+		Status      schema.Status `json:"status" validate:"omitempty,xStatus"`
 	}
 	storageUpdateParams struct {
-		Name        string `json:"name" validate:"omitempty,xStorageName"`
-		Category    string `json:"category" validate:"omitempty,xStorageCategory"`
-		URI         string `json:"uri" validate:"omitempty,xStorageURI"`
-		Description string `json:"description" validate:"omitempty,xStorageDescription"`
+		Name        string        `json:"name" validate:"omitempty,xStorageName"`
+		Category    string        `json:"category" validate:"omitempty,xStorageCategory"`
+		URI         string        `json:"uri" validate:"omitempty,xStorageURI"`
+		Description string        `json:"description" validate:"omitempty,xStorageDescription"`
+		Status      schema.Status `json:"status" validate:"omitempty,xStatus"`
+	}
+	// storageTestParams 测试storage的参数，指定params则获取该图片
+	storageTestParams struct {
+		Params []string `json:"params" validate:"omitempty,max=10,dive,min=1,max=1024"`
 	}
 )
 
 type (
+	// storageResp storage以及其健康状态
+	storageResp struct {
+		*ent.Storage
+		Health *storage.StorageStatus `json:"health,omitempty"`
+	}
 	storageListResp struct {
-		Storages []*ent.Storage `json:"storages"`
+		Storages []*storageResp `json:"storages"`
 	}
 )
 
@@ -76,21 +91,83 @@ func init() {
 		shouldBeAdmin,
 		ctrl.findByID,
 	)
+	g.POST(
+		"/v1/{id}/test",
+		newTrackerMiddleware(cs.ActionStorageTest),
+		shouldBeAdmin,
+		ctrl.test,
+	)
+}
+
+// saveStorage 在事务中保存storage后重新初始化，初始化失败则回滚并返回出错
+func saveStorage(ctx context.Context, fn func(client *ent.StorageClient) (*ent.Storage, error)) (*ent.Storage, error) {
+	tx, err := helper.EntGetClient().Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := fn(tx.Storage)
+	if err == nil {
+		err = storage.ReloadStorage(result)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		// 提交失败则按数据库中的配置重新初始化
+		_ = storage.RefreshStorages(ctx)
+		return nil, err
+	}
+	return result, nil
 }
 
 func (params *storageAddParams) save(ctx context.Context) (*ent.Storage, error) {
-	return getStorageClient().Create().
-		SetName(params.Name).
-		SetCategory(storage.Category(params.Category)).
-		SetURI(params.URI).
-		SetDescription(params.Description).
-		Save(ctx)
+	return saveStorage(ctx, func(client *ent.StorageClient) (*ent.Storage, error) {
+		create := client.Create().
+			SetName(params.Name).
+			SetCategory(entStorage.Category(params.Category)).
+			SetURI(params.URI).
+			SetDescription(params.Description)
+		if params.Status != 0 {
+			create.SetStatus(params.Status)
+		}
+		return create.Save(ctx)
+	})
 }
 
 func (params *storageUpdateParams) update(ctx context.Context, id int) (*ent.Storage, error) {
-	update := getStorageClient().UpdateOneID(id)
+	current, err := getStorageClient().Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// 图片通过名称引用storage，有引用时不可修改名称
+	if params.Name != "" && params.Name != current.Name {
+		used, err := storage.IsStorageUsed(ctx, current.Name)
+		if err != nil {
+			return nil, err
+		}
+		if used {
+			return nil, hes.New("storage is used by images, its name can not be modified")
+		}
+	}
+	result, err := saveStorage(ctx, func(client *ent.StorageClient) (*ent.Storage, error) {
+		return params.doUpdate(ctx, client, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 修改名称后删除原有的storage
+	if current.Name != result.Name {
+		storage.RemoveStorage(current.Name)
+	}
+	return result, nil
+}
+
+func (params *storageUpdateParams) doUpdate(ctx context.Context, client *ent.StorageClient, id int) (*ent.Storage, error) {
+	update := client.UpdateOneID(id)
 	if params.Category != "" {
-		update.SetCategory(storage.Category(params.Category))
+		update.SetCategory(entStorage.Category(params.Category))
 	}
 	if params.Name != "" {
 		update.SetName(params.Name)
@@ -101,6 +178,9 @@ func (params *storageUpdateParams) update(ctx context.Context, id int) (*ent.Sto
 	if params.Description != "" {
 		update.SetDescription(params.Description)
 	}
+	if params.Status != 0 {
+		update.SetStatus(params.Status)
+	}
 	return update.Save(ctx)
 }
 
@@ -130,8 +210,15 @@ func (*storageCtrl) list(c *elton.Context) error {
 	if err != nil {
 		return err
 	}
+	items := make([]*storageResp, len(storages))
+	for index, item := range storages {
+		items[index] = &storageResp{
+			Storage: item,
+			Health:  storage.GetStorageStatus(item.Name),
+		}
+	}
 	c.Body = &storageListResp{
-		Storages: storages,
+		Storages: items,
 	}
 	return nil
 }
@@ -159,10 +246,32 @@ func (*storageCtrl) findByID(c *elton.Context) error {
 	if err != nil {
 		return err
 	}
-	storage, err := getStorageClient().Get(c.Context(), id)
+	result, err := getStorageClient().Get(c.Context(), id)
+	if err != nil {
+		return err
+	}
+	c.Body = result
+	return nil
+}
+
+// test 使用storage的配置测试连接，返回耗时以及出错信息
+func (*storageCtrl) test(c *elton.Context) error {
+	params := storageTestParams{}
+	// 参数可选，未指定则仅检测连接
+	if len(c.RequestBody) != 0 {
+		err := validateBody(c, &params)
+		if err != nil {
+			return err
+		}
+	}
+	id, err := getIDFromParams(c)
+	if err != nil {
+		return err
+	}
+	result, err := getStorageClient().Get(c.Context(), id)
 	if err != nil {
 		return err
 	}
-	c.Body = storage
+	c.Body = storage.TestStorage(c.Context(), result, params.Params...)
 	return nil
 }
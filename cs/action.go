@@ -33,6 +33,8 @@ const (
 
 	// ActionAdminCleanCache clean cache
 	ActionAdminCleanCache = "cleanCache"
+	// ActionAdminCleanPipelineCache clean pipeline cache
+	ActionAdminCleanPipelineCache = "cleanPipelineCache"
 
 	// ActionBucketCreate add bucket
 	ActionBucketAdd = "addBucket"
@@ -40,9 +42,28 @@ const (
 	ActionBucketUpdate = "updateBucket"
 	// ActionImageAdd add image
 	ActionImageAdd = "addImage"
+	// ActionImageImport import images
+	ActionImageImport = "importImage"
+	// ActionImageReplace replace image
+	ActionImageReplace = "replaceImage"
+	// ActionImageRollback rollback image to the version
+	ActionImageRollback = "rollbackImage"
+	// ActionImageDelete delete image
+	ActionImageDelete = "deleteImage"
+	// ActionImageRestore restore image from trash
+	ActionImageRestore = "restoreImage"
 
 	// ActionStorageAdd add storage
 	ActionStorageAdd = "addStorage"
 	// ActionStorageUpdate update storage
 	ActionStorageUpdate = "updateStorage"
+	// ActionStorageTest test storage
+	ActionStorageTest = "testStorage"
+
+	// ActionPresetAdd add preset
+	ActionPresetAdd = "addPreset"
+	// ActionPresetUpdate update preset
+	ActionPresetUpdate = "updatePreset"
+	// ActionPresetDelete delete preset
+	ActionPresetDelete = "deletePreset"
 )
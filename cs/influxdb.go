@@ -52,6 +52,8 @@ const (
 	MeasurementUserAddTrack = "userAddTrack"
 	// MeasurementException 异常
 	MeasurementException = "exception"
+	// MeasurementStorageHealth storage健康检测
+	MeasurementStorageHealth = "storageHealth"
 )
 
 const (
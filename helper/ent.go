@@ -236,6 +236,35 @@ func (stats *entProcessingStats) dec(schema string) (int32, int32) {
 	return total, p.Dec()
 }
 
+type entDeleteReasonKey struct{}
+
+// EntAllowDelete 允许删除数据，需指定删除的原因用于审计
+func EntAllowDelete(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, entDeleteReasonKey{}, reason)
+}
+
+// auditEntDelete 未指定删除原因的则拒绝，删除时记录原因
+func auditEntDelete(next ent.Mutator) ent.Mutator {
+	return ent.MutateFunc(func(ctx context.Context, m ent.Mutation) (ent.Value, error) {
+		reason, _ := ctx.Value(entDeleteReasonKey{}).(string)
+		if reason == "" {
+			return nil, hes.New(m.Op().String() + " operation is not allowed")
+		}
+		event := log.Info(ctx).
+			Str("category", "entDelete").
+			Str("schema", m.Type()).
+			Str("op", m.Op().String()).
+			Str("reason", reason)
+		if mutation, ok := m.(interface{ ID() (int, bool) }); ok {
+			if id, exists := mutation.ID(); exists {
+				event.Int("id", id)
+			}
+		}
+		event.Msg("")
+		return next.Mutate(ctx, m)
+	})
+}
+
 // initSchemaHooks 初始化相关的hooks
 func initSchemaHooks(c *ent.Client) {
 	schemas := make([]string, len(migrate.Tables))
@@ -257,8 +286,8 @@ func initSchemaHooks(c *ent.Client) {
 		}
 		return false
 	}
-	// 禁止删除数据
-	c.Use(hook.Reject(ent.OpDelete | ent.OpDeleteOne))
+	// 禁止删除数据，仅允许通过EntAllowDelete指定原因后删除，并记录日志
+	c.Use(hook.On(auditEntDelete, ent.OpDelete|ent.OpDeleteOne))
 	// 数据库操作统计
 	c.Use(func(next ent.Mutator) ent.Mutator {
 		return ent.MutateFunc(func(ctx context.Context, m ent.Mutation) (ent.Value, error) {
@@ -0,0 +1,132 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const blurhashCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+const (
+	// blurhash的分量数量
+	blurhashXComponents = 4
+	blurhashYComponents = 3
+	// 计算前先缩小图片，占位图无需细节
+	blurhashSampleSize = 32
+)
+
+func encodeBase83(builder *strings.Builder, value, length int) {
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		builder.WriteByte(blurhashCharacters[digit])
+	}
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+// EncodeBlurhash 生成图片的blurhash(https://blurha.sh)，用于前端展示占位图
+func EncodeBlurhash(img image.Image) string {
+	sample := imaging.Resize(img, blurhashSampleSize, blurhashSampleSize, imaging.Box)
+	width := sample.Bounds().Dx()
+	height := sample.Bounds().Dy()
+	// 预先转换为线性的颜色值
+	pixels := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			offset := sample.PixOffset(x, y)
+			pixels[y*width+x] = [3]float64{
+				sRGBToLinear(sample.Pix[offset]),
+				sRGBToLinear(sample.Pix[offset+1]),
+				sRGBToLinear(sample.Pix[offset+2]),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, blurhashXComponents*blurhashYComponents)
+	for j := 0; j < blurhashYComponents; j++ {
+		for i := 0; i < blurhashXComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			factor := [3]float64{}
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					pixel := pixels[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{
+				factor[0] * scale,
+				factor[1] * scale,
+				factor[2] * scale,
+			})
+		}
+	}
+
+	builder := &strings.Builder{}
+	encodeBase83(builder, (blurhashXComponents-1)+(blurhashYComponents-1)*9, 1)
+
+	dc := factors[0]
+	ac := factors[1:]
+	actualMax := 0.0
+	for _, factor := range ac {
+		for _, value := range factor {
+			actualMax = math.Max(actualMax, math.Abs(value))
+		}
+	}
+	quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+	maxValue := float64(quantisedMax+1) / 166
+	encodeBase83(builder, quantisedMax, 1)
+
+	encodeBase83(builder, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+
+	quant := func(value float64) int {
+		return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maxValue, 0.5)*9+9.5))))
+	}
+	for _, factor := range ac {
+		encodeBase83(builder, quant(factor[0])*19*19+quant(factor[1])*19+quant(factor[2]), 2)
+	}
+	return builder.String()
+}
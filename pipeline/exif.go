@@ -0,0 +1,404 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/schema"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+const (
+	jpegMarkerSOS   = 0xda
+	jpegMarkerCOM   = 0xfe
+	jpegMarkerAPP0  = 0xe0
+	jpegMarkerAPP1  = 0xe1
+	jpegMarkerAPP2  = 0xe2
+	jpegMarkerAPP14 = 0xee
+	jpegMarkerAPP15 = 0xef
+
+	exifTagGPSInfo     = 0x8825
+	exifTagOrientation = 0x0112
+)
+
+var (
+	exifHeader         = []byte("Exif\x00\x00")
+	jpegICCHeader      = []byte("ICC_PROFILE\x00")
+	pngSignature       = []byte("\x89PNG\r\n\x1a\n")
+	metadataGPSKeyword = []byte("GPS")
+)
+
+// exifTypeSizes EXIF中各数据类型的字节数
+var exifTypeSizes = map[uint16]int{
+	1:  1,
+	2:  1,
+	3:  2,
+	4:  4,
+	5:  8,
+	6:  1,
+	7:  1,
+	8:  2,
+	9:  4,
+	10: 8,
+	11: 4,
+	12: 8,
+}
+
+var ErrImageMetadataInvalid = hes.New("metadata of image is invalid")
+
+// exifDerivedMetadata 从EXIF中提取的metadata字段，
+// 方向在移除全部元数据时仍保留，因此不包括
+var exifDerivedMetadata = []string{
+	MetadataCameraMake,
+	MetadataCameraModel,
+	MetadataTakenAt,
+	MetadataGPSLatitude,
+	MetadataGPSLongitude,
+}
+
+func clearBytes(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// clearExifIFD 将IFD及其引用的数据置为0，数据不合法时返回false
+func clearExifIFD(tiff []byte, order binary.ByteOrder, offset int) bool {
+	if offset < 8 || offset+2 > len(tiff) {
+		return false
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	end := offset + 2 + 12*count + 4
+	if end > len(tiff) {
+		return false
+	}
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + 12*i
+		typeSize, ok := exifTypeSizes[order.Uint16(tiff[entry+2:])]
+		if !ok {
+			return false
+		}
+		size := typeSize * int(order.Uint32(tiff[entry+4:]))
+		// 不超过4字节的数据保存在条目中
+		if size <= 4 {
+			continue
+		}
+		valueOffset := int(order.Uint32(tiff[entry+8:]))
+		if valueOffset+size > len(tiff) {
+			return false
+		}
+		clearBytes(tiff[valueOffset : valueOffset+size])
+	}
+	clearBytes(tiff[offset:end])
+	return true
+}
+
+// stripExifGPS 移除EXIF(tiff格式)中的GPS信息，
+// 删除IFD0中GPS的指针并将GPS的数据置为0，数据不合法时返回false
+func stripExifGPS(data []byte) ([]byte, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, false
+	}
+	tiff := make([]byte, len(data))
+	copy(tiff, data)
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil, false
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	end := ifd + 2 + 12*count + 4
+	if end > len(tiff) {
+		return nil, false
+	}
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if order.Uint16(tiff[entry:]) != exifTagGPSInfo {
+			continue
+		}
+		if !clearExifIFD(tiff, order, int(order.Uint32(tiff[entry+8:]))) {
+			return nil, false
+		}
+		// 后续的条目以及下一个IFD的位置前移
+		copy(tiff[entry:end-12], tiff[entry+12:end])
+		clearBytes(tiff[end-12 : end])
+		order.PutUint16(tiff[ifd:], uint16(count-1))
+		break
+	}
+	return tiff, true
+}
+
+// stripExifPayloadGPS 移除EXIF数据中的GPS信息，兼容以"Exif\0\0"开头的数据
+func stripExifPayloadGPS(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, exifHeader) {
+		return stripExifGPS(data)
+	}
+	tiff, ok := stripExifGPS(data[len(exifHeader):])
+	if !ok {
+		return nil, false
+	}
+	return append(append([]byte{}, exifHeader...), tiff...), true
+}
+
+// newOrientationExif 生成仅包含方向的EXIF，用于移除全部元数据时保留图片的方向，
+// 方向为1或EXIF无法解析时返回false
+func newOrientationExif(payload []byte) ([]byte, bool) {
+	x, err := exif.Decode(bytes.NewReader(payload[len(exifHeader):]))
+	if err != nil {
+		return nil, false
+	}
+	orientation := storage.ExifOrientation(x)
+	if orientation == 1 {
+		return nil, false
+	}
+	result := bytes.NewBuffer(append([]byte{}, exifHeader...))
+	result.WriteString("MM")
+	// tiff的标记、IFD0的位置以及条目数
+	_ = binary.Write(result, binary.BigEndian, uint16(0x2a))
+	_ = binary.Write(result, binary.BigEndian, uint32(8))
+	_ = binary.Write(result, binary.BigEndian, uint16(1))
+	// 方向的条目：类型为SHORT，数量为1，值保存在条目中
+	_ = binary.Write(result, binary.BigEndian, uint16(exifTagOrientation))
+	_ = binary.Write(result, binary.BigEndian, uint16(3))
+	_ = binary.Write(result, binary.BigEndian, uint32(1))
+	_ = binary.Write(result, binary.BigEndian, uint16(orientation))
+	_ = binary.Write(result, binary.BigEndian, uint16(0))
+	// 无下一个IFD
+	_ = binary.Write(result, binary.BigEndian, uint32(0))
+	return result.Bytes(), true
+}
+
+// filterJPEGSegment 按策略处理jpeg的segment，返回处理后的数据以及是否保留
+func filterJPEGSegment(marker byte, payload []byte, policy string) ([]byte, bool) {
+	isExif := marker == jpegMarkerAPP1 && bytes.HasPrefix(payload, exifHeader)
+	if policy == schema.BucketExifPolicyStripGPS {
+		switch {
+		case isExif:
+			// 无法解析的EXIF直接删除
+			return stripExifPayloadGPS(payload)
+		case marker == jpegMarkerAPP1:
+			// XMP中包含GPS信息则删除
+			return payload, !bytes.Contains(payload, metadataGPSKeyword)
+		}
+		return payload, true
+	}
+	switch {
+	// 图像未按EXIF的方向调整，仅保留方向
+	case isExif:
+		return newOrientationExif(payload)
+	case marker == jpegMarkerAPP2:
+		return payload, bytes.HasPrefix(payload, jpegICCHeader)
+	// JFIF与Adobe影响解码，保留
+	case marker == jpegMarkerAPP0, marker == jpegMarkerAPP14:
+		return payload, true
+	case marker >= jpegMarkerAPP0 && marker <= jpegMarkerAPP15, marker == jpegMarkerCOM:
+		return nil, false
+	}
+	return payload, true
+}
+
+func stripJPEGMetadata(data []byte, policy string) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, ErrImageMetadataInvalid
+	}
+	result := bytes.NewBuffer(make([]byte, 0, len(data)))
+	result.Write(data[:2])
+	offset := 2
+	for offset+4 <= len(data) {
+		if data[offset] != 0xff {
+			return nil, ErrImageMetadataInvalid
+		}
+		marker := data[offset+1]
+		// 填充的字节
+		if marker == 0xff {
+			offset++
+			continue
+		}
+		// SOS之后为图像数据，不再包含元数据
+		if marker == jpegMarkerSOS {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrImageMetadataInvalid
+		}
+		payload, keep := filterJPEGSegment(marker, data[offset+4:end], policy)
+		if keep {
+			result.Write([]byte{0xff, marker})
+			_ = binary.Write(result, binary.BigEndian, uint16(len(payload)+2))
+			result.Write(payload)
+		}
+		offset = end
+	}
+	result.Write(data[offset:])
+	return result.Bytes(), nil
+}
+
+func writePNGChunk(buffer *bytes.Buffer, name string, data []byte) {
+	_ = binary.Write(buffer, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write([]byte(name))
+	_, _ = crc.Write(data)
+	buffer.WriteString(name)
+	buffer.Write(data)
+	_ = binary.Write(buffer, binary.BigEndian, crc.Sum32())
+}
+
+func stripPNGMetadata(data []byte, policy string) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrImageMetadataInvalid
+	}
+	result := bytes.NewBuffer(make([]byte, 0, len(data)))
+	result.Write(pngSignature)
+	offset := len(pngSignature)
+	for offset < len(data) {
+		if offset+12 > len(data) {
+			return nil, ErrImageMetadataInvalid
+		}
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		end := offset + 12 + length
+		if end > len(data) {
+			return nil, ErrImageMetadataInvalid
+		}
+		name := string(data[offset+4 : offset+8])
+		chunk := data[offset+8 : offset+8+length]
+		switch name {
+		case "eXIf":
+			if policy == schema.BucketExifPolicyStripGPS {
+				if tiff, ok := stripExifPayloadGPS(chunk); ok {
+					writePNGChunk(result, name, tiff)
+				}
+			}
+		// 文本中可能包含XMP
+		case "tEXt", "zTXt", "iTXt", "tIME":
+			if policy == schema.BucketExifPolicyStripGPS && !bytes.Contains(chunk, metadataGPSKeyword) {
+				result.Write(data[offset:end])
+			}
+		default:
+			result.Write(data[offset:end])
+		}
+		offset = end
+	}
+	return result.Bytes(), nil
+}
+
+func stripWEBPMetadata(data []byte, policy string) ([]byte, error) {
+	chunks, err := parseWebpChunks(data)
+	if err != nil {
+		return nil, ErrImageMetadataInvalid
+	}
+	result := bytes.NewBuffer(make([]byte, 0, len(data)))
+	result.Write(data[:12])
+	flagsOffset := -1
+	var flags byte
+	for _, chunk := range chunks {
+		switch chunk.fourCC {
+		case "EXIF":
+			if policy != schema.BucketExifPolicyStripGPS {
+				continue
+			}
+			tiff, ok := stripExifPayloadGPS(chunk.data)
+			if !ok {
+				continue
+			}
+			flags |= webpFlagExif
+			writeWebpChunk(result, chunk.fourCC, tiff)
+		case "XMP ":
+			if policy != schema.BucketExifPolicyStripGPS || bytes.Contains(chunk.data, metadataGPSKeyword) {
+				continue
+			}
+			flags |= webpFlagXMP
+			writeWebpChunk(result, chunk.fourCC, chunk.data)
+		default:
+			if chunk.fourCC == "VP8X" && len(chunk.data) != 0 {
+				flagsOffset = result.Len() + 8
+			}
+			writeWebpChunk(result, chunk.fourCC, chunk.data)
+		}
+	}
+	buf := result.Bytes()
+	// 删除的chunk需要同时清除VP8X中的标记
+	if flagsOffset >= 0 {
+		buf[flagsOffset] &^= (webpFlagExif | webpFlagXMP) &^ flags
+	}
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-8))
+	return buf, nil
+}
+
+// StripMetadata 按bucket的策略移除图片中的元数据，
+// 仅处理jpeg、png与webp。avif、gif与tiff的元数据不作处理，
+// 与保留元数据的策略一样均返回原数据，如需移除则要先转换为以上格式
+func StripMetadata(data []byte, format, policy string) ([]byte, error) {
+	if policy != schema.BucketExifPolicyStripGPS &&
+		policy != schema.BucketExifPolicyStripAll {
+		return data, nil
+	}
+	switch format {
+	case ImageTypeJPEG:
+		return stripJPEGMetadata(data, policy)
+	case ImageTypePNG:
+		return stripPNGMetadata(data, policy)
+	case ImageTypeWEBP:
+		return stripWEBPMetadata(data, policy)
+	}
+	return data, nil
+}
+
+// ApplyExifPolicy 按bucket的策略移除图片数据中的元数据，
+// 以及从EXIF中提取的metadata(主色调与blurhash不受影响)
+func (result *ImageMetadataResult) ApplyExifPolicy(format, policy string) error {
+	data, err := StripMetadata(result.Data, format, policy)
+	if err != nil {
+		return err
+	}
+	result.Data = data
+	switch policy {
+	case schema.BucketExifPolicyStripGPS:
+		result.Metadata.Del(MetadataGPSLatitude)
+		result.Metadata.Del(MetadataGPSLongitude)
+	case schema.BucketExifPolicyStripAll:
+		for _, key := range exifDerivedMetadata {
+			result.Metadata.Del(key)
+		}
+	}
+	return nil
+}
+
+// stripImageMetadata 按图片所在bucket的策略移除元数据，需在重新编码后调用
+func stripImageMetadata(img *storage.Image) error {
+	if img.ExifPolicy == "" || img.ExifPolicy == schema.BucketExifPolicyKeep {
+		return nil
+	}
+	data, err := StripMetadata(img.Data, img.Type, img.ExifPolicy)
+	if err != nil {
+		return err
+	}
+	img.SetData(data)
+	return nil
+}
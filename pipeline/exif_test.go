@@ -0,0 +1,268 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/schema"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+var testICCProfile = []byte("ICC_PROFILE\x00\x01\x01test-icc-profile")
+
+// assertNoGPS 校验数据中不包含GPS的信息
+func assertNoGPS(t *testing.T, data []byte) {
+	assert := assert.New(t)
+	assert.False(bytes.Contains(data, testRational(22, 30, 0)))
+	assert.False(bytes.Contains(data, testRational(114, 15, 0)))
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	_, err = x.Get(exif.GPSLatitude)
+	assert.NotNil(err)
+	_, _, err = x.LatLong()
+	assert.NotNil(err)
+}
+
+// insertJPEGSegment 在SOI之后插入segment
+func insertJPEGSegment(data []byte, marker byte, payload []byte) []byte {
+	result := &bytes.Buffer{}
+	result.Write(data[:2])
+	result.Write([]byte{0xff, marker})
+	_ = binary.Write(result, binary.BigEndian, uint16(len(payload)+2))
+	result.Write(payload)
+	result.Write(data[2:])
+	return result.Bytes()
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	// EXIF需为第一个APP1
+	data := newTestJPEG(t, 10, nil)
+	data = insertJPEGSegment(data, jpegMarkerCOM, []byte("comment"))
+	data = insertJPEGSegment(data, jpegMarkerAPP1, []byte("http://ns.adobe.com/xap/1.0/\x00<exif:GPSLatitude>22,30N</exif:GPSLatitude>"))
+	data = insertJPEGSegment(data, jpegMarkerAPP2, testICCProfile)
+	data = insertJPEGSegment(data, jpegMarkerAPP1, newTestExif(1))
+
+	result, err := StripMetadata(data, ImageTypeJPEG, schema.BucketExifPolicyKeep)
+	assert.Nil(err)
+	assert.Equal(data, result)
+	x, err := exif.Decode(bytes.NewReader(result))
+	assert.Nil(err)
+	lat, long, err := x.LatLong()
+	assert.Nil(err)
+	assert.Equal(22.5, lat)
+	assert.Equal(114.25, long)
+
+	// 仅移除GPS，其它EXIF信息保留
+	result, err = StripMetadata(data, ImageTypeJPEG, schema.BucketExifPolicyStripGPS)
+	assert.Nil(err)
+	assertNoGPS(t, result)
+	assert.False(bytes.Contains(result, []byte("exif:GPSLatitude")))
+	x, err = exif.Decode(bytes.NewReader(result))
+	assert.Nil(err)
+	assert.Equal("Test", exifString(x, exif.Make))
+	_, err = x.DateTime()
+	assert.Nil(err)
+	assert.True(bytes.Contains(result, testICCProfile))
+	assert.True(bytes.Contains(result, []byte("comment")))
+	img, _, err := image.Decode(bytes.NewReader(result))
+	assert.Nil(err)
+	assert.Equal(20, img.Bounds().Dx())
+
+	// 仅保留ICC profile
+	result, err = StripMetadata(data, ImageTypeJPEG, schema.BucketExifPolicyStripAll)
+	assert.Nil(err)
+	assertNoGPS(t, result)
+	_, err = exif.Decode(bytes.NewReader(result))
+	assert.NotNil(err)
+	assert.False(bytes.Contains(result, exifHeader))
+	assert.False(bytes.Contains(result, []byte("comment")))
+	assert.True(bytes.Contains(result, testICCProfile))
+	img, _, err = image.Decode(bytes.NewReader(result))
+	assert.Nil(err)
+	assert.Equal(20, img.Bounds().Dx())
+
+	_, err = StripMetadata([]byte("abcd"), ImageTypeJPEG, schema.BucketExifPolicyStripAll)
+	assert.Equal(ErrImageMetadataInvalid, err)
+
+	// 方向需调整的仅保留方向
+	data = insertJPEGSegment(newTestJPEG(t, 10, nil), jpegMarkerAPP1, newTestExif(6))
+	result, err = StripMetadata(data, ImageTypeJPEG, schema.BucketExifPolicyStripAll)
+	assert.Nil(err)
+	assertNoGPS(t, result)
+	x, err = exif.Decode(bytes.NewReader(result))
+	assert.Nil(err)
+	assert.Equal(6, storage.ExifOrientation(x))
+	assert.Empty(exifString(x, exif.Make))
+	_, err = x.DateTime()
+	assert.NotNil(err)
+	assert.Equal(6, storage.ImageOrientation(result))
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+	assert.Nil(err)
+	raw := buf.Bytes()
+	// IHDR之后插入元数据
+	ihdrEnd := len(pngSignature) + 12 + 13
+	chunks := &bytes.Buffer{}
+	writePNGChunk(chunks, "iCCP", testICCProfile)
+	writePNGChunk(chunks, "eXIf", newTestExif(1)[len(exifHeader):])
+	writePNGChunk(chunks, "tEXt", []byte("Comment\x00hello"))
+	data := append(append(append([]byte{}, raw[:ihdrEnd]...), chunks.Bytes()...), raw[ihdrEnd:]...)
+
+	result, err := StripMetadata(data, ImageTypePNG, schema.BucketExifPolicyStripGPS)
+	assert.Nil(err)
+	assertNoGPS(t, result)
+	assert.True(bytes.Contains(result, []byte("eXIf")))
+	assert.True(bytes.Contains(result, []byte("Test\x00")))
+	assert.True(bytes.Contains(result, []byte("hello")))
+	_, err = png.Decode(bytes.NewReader(result))
+	assert.Nil(err)
+
+	result, err = StripMetadata(data, ImageTypePNG, schema.BucketExifPolicyStripAll)
+	assert.Nil(err)
+	assertNoGPS(t, result)
+	assert.False(bytes.Contains(result, []byte("eXIf")))
+	assert.False(bytes.Contains(result, []byte("hello")))
+	assert.True(bytes.Contains(result, testICCProfile))
+	_, err = png.Decode(bytes.NewReader(result))
+	assert.Nil(err)
+}
+
+func TestStripWEBPMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	newWEBP := func() []byte {
+		body := &bytes.Buffer{}
+		// VP8X：包含ICC、EXIF与XMP
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x20 | webpFlagExif | webpFlagXMP
+		writeWebpChunk(body, "VP8X", vp8x)
+		writeWebpChunk(body, "ICCP", testICCProfile)
+		writeWebpChunk(body, "VP8L", []byte{0x2f, 0x01, 0x02})
+		writeWebpChunk(body, "EXIF", newTestExif(1))
+		writeWebpChunk(body, "XMP ", []byte("<exif:GPSLatitude>22,30N</exif:GPSLatitude>"))
+		buf := bytes.NewBufferString("RIFF")
+		_ = binary.Write(buf, binary.LittleEndian, uint32(body.Len()+4))
+		buf.WriteString("WEBP")
+		buf.Write(body.Bytes())
+		return buf.Bytes()
+	}
+	data := newWEBP()
+
+	result, err := StripMetadata(data, ImageTypeWEBP, schema.BucketExifPolicyStripGPS)
+	assert.Nil(err)
+	assertNoGPS(t, result)
+	assert.True(bytes.Contains(result, []byte("EXIF")))
+	assert.False(bytes.Contains(result, []byte("XMP ")))
+	assert.Equal(byte(0x20|webpFlagExif), result[20])
+	assert.Equal(uint32(len(result)-8), binary.LittleEndian.Uint32(result[4:]))
+
+	result, err = StripMetadata(data, ImageTypeWEBP, schema.BucketExifPolicyStripAll)
+	assert.Nil(err)
+	assertNoGPS(t, result)
+	assert.False(bytes.Contains(result, []byte("EXIF")))
+	assert.True(bytes.Contains(result, testICCProfile))
+	assert.Equal(byte(0x20), result[20])
+	assert.Equal(uint32(len(result)-8), binary.LittleEndian.Uint32(result[4:]))
+}
+
+func TestStripMetadataPassThrough(t *testing.T) {
+	assert := assert.New(t)
+
+	// avif、gif与tiff不处理元数据，原样返回
+	data := append([]byte("GPS"), newTestExif(1)...)
+	for _, format := range []string{
+		ImageTypeAVIF,
+		ImageTypeGIF,
+		ImageTypeTIFF,
+	} {
+		result, err := StripMetadata(data, format, schema.BucketExifPolicyStripAll)
+		assert.Nil(err)
+		assert.Equal(data, result)
+	}
+
+	_, err := StripMetadata(data, ImageTypeWEBP, schema.BucketExifPolicyStripAll)
+	assert.Equal(ErrImageMetadataInvalid, err)
+}
+
+func TestDoStripMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	data := newTestJPEG(t, 10, newTestExif(1))
+	newJob := func(policy string) ImageJob {
+		return func(_ context.Context, _ *storage.Image) (*storage.Image, error) {
+			return &storage.Image{
+				Type:       ImageTypeJPEG,
+				Size:       len(data),
+				Data:       data,
+				ExifPolicy: policy,
+			}, nil
+		}
+	}
+
+	img, err := Do(context.Background(), nil, newJob(schema.BucketExifPolicyKeep))
+	assert.Nil(err)
+	assert.Equal(data, img.Data)
+
+	// 未调整的图片也需移除GPS
+	img, err = Do(context.Background(), nil, newJob(schema.BucketExifPolicyStripGPS))
+	assert.Nil(err)
+	assertNoGPS(t, img.Data)
+	assert.Equal(len(img.Data), img.Size)
+	x, err := exif.Decode(bytes.NewReader(img.Data))
+	assert.Nil(err)
+	assert.Equal("Test", exifString(x, exif.Make))
+}
+
+func TestApplyExifPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	data := newTestJPEG(t, 10, newTestExif(1))
+	img, format, err := image.Decode(bytes.NewReader(data))
+	assert.Nil(err)
+
+	result := ExtractMetadata(data, img)
+	err = result.ApplyExifPolicy(format, schema.BucketExifPolicyStripGPS)
+	assert.Nil(err)
+	assertNoGPS(t, result.Data)
+	assert.Empty(result.Metadata.Get(MetadataGPSLatitude))
+	assert.Empty(result.Metadata.Get(MetadataGPSLongitude))
+	assert.Equal("Test", result.Metadata.Get(MetadataCameraMake))
+
+	result = ExtractMetadata(data, img)
+	err = result.ApplyExifPolicy(format, schema.BucketExifPolicyStripAll)
+	assert.Nil(err)
+	assertNoGPS(t, result.Data)
+	assert.Empty(result.Metadata.Get(MetadataCameraMake))
+	assert.Empty(result.Metadata.Get(MetadataTakenAt))
+	assert.NotEmpty(result.Metadata.Get(MetadataBlurhash))
+	assert.NotEmpty(result.Metadata.Values(MetadataDominantColor))
+}
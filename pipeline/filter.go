@@ -0,0 +1,85 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"image"
+
+	"github.com/disintegration/imaging"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+type filterHandler func(image.Image) *image.NRGBA
+
+func newFilterImage(fn filterHandler) ImageJob {
+	return func(_ context.Context, img *storage.Image) (*storage.Image, error) {
+		return transform(img, func(srcImage image.Image) (image.Image, error) {
+			return fn(srcImage), nil
+		})
+	}
+}
+
+// NewBlurImage 高斯模糊，sigma越大越模糊
+func NewBlurImage(sigma float64) ImageJob {
+	return newFilterImage(func(i image.Image) *image.NRGBA {
+		return imaging.Blur(i, sigma)
+	})
+}
+
+// NewSharpenImage 锐化
+func NewSharpenImage(sigma float64) ImageJob {
+	return newFilterImage(func(i image.Image) *image.NRGBA {
+		return imaging.Sharpen(i, sigma)
+	})
+}
+
+// NewGrayscaleImage 灰度化
+func NewGrayscaleImage() ImageJob {
+	return newFilterImage(imaging.Grayscale)
+}
+
+// NewInvertImage 反色
+func NewInvertImage() ImageJob {
+	return newFilterImage(imaging.Invert)
+}
+
+// NewBrightnessImage 调整亮度，percentage为-100至100
+func NewBrightnessImage(percentage float64) ImageJob {
+	return newFilterImage(func(i image.Image) *image.NRGBA {
+		return imaging.AdjustBrightness(i, percentage)
+	})
+}
+
+// NewContrastImage 调整对比度，percentage为-100至100
+func NewContrastImage(percentage float64) ImageJob {
+	return newFilterImage(func(i image.Image) *image.NRGBA {
+		return imaging.AdjustContrast(i, percentage)
+	})
+}
+
+// NewSaturationImage 调整饱和度，percentage为-100至500
+func NewSaturationImage(percentage float64) ImageJob {
+	return newFilterImage(func(i image.Image) *image.NRGBA {
+		return imaging.AdjustSaturation(i, percentage)
+	})
+}
+
+// NewGammaImage gamma校正，gamma小于1变暗，大于1变亮
+func NewGammaImage(gamma float64) ImageJob {
+	return newFilterImage(func(i image.Image) *image.NRGBA {
+		return imaging.AdjustGamma(i, gamma)
+	})
+}
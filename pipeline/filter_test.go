@@ -0,0 +1,57 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/hes"
+)
+
+func TestFilterImage(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, job := range []ImageJob{
+		NewBlurImage(2),
+		NewSharpenImage(1),
+		NewGrayscaleImage(),
+		NewInvertImage(),
+		NewBrightnessImage(20),
+		NewContrastImage(-20),
+		NewSaturationImage(50),
+		NewGammaImage(1.5),
+	} {
+		img, err := job(context.Background(), newTestImage(t, 20, 10))
+		assert.Nil(err)
+		assert.Equal(20, img.Width)
+		assert.Equal(10, img.Height)
+	}
+}
+
+func TestParseFloatParam(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := parseFloatParam([]string{"blur"}, 0.1, 50)
+	assert.Equal("blur params is invalid", err.(*hes.Error).Message)
+
+	_, err = parseFloatParam([]string{"blur", "100"}, 0.1, 50)
+	assert.Equal("blur params should be 0.1-50", err.(*hes.Error).Message)
+
+	value, err := parseFloatParam([]string{"brightness", "-20"}, -100, 100)
+	assert.Nil(err)
+	assert.Equal(-20.0, value)
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+
+	"github.com/vicanso/tiny-site/storage"
+)
+
+// encodeAnimation 将动图的所有帧编码为gif，
+// 每帧使用原有的调色板，由于每帧均为完整画面，因此disposal为清除背景
+func encodeAnimation(anim *storage.Animation) ([]byte, error) {
+	g := &gif.GIF{
+		LoopCount: anim.LoopCount,
+	}
+	for index, frame := range anim.Frames {
+		p := palette.Plan9
+		if index < len(anim.Palettes) && len(anim.Palettes[index]) != 0 {
+			p = anim.Palettes[index]
+		}
+		bounds := frame.Bounds()
+		paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), p)
+		draw.Draw(paletted, paletted.Bounds(), frame, bounds.Min, draw.Src)
+
+		delay := 0
+		if index < len(anim.Delays) {
+			delay = anim.Delays[index]
+		}
+		g.Image = append(g.Image, paletted)
+		g.Delay = append(g.Delay, delay)
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	buffer := bytes.Buffer{}
+	err := gif.EncodeAll(&buffer, g)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
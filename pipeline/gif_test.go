@@ -0,0 +1,134 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+// newTestGIF 生成动图，第一帧为完整画面，后续帧只更新部分区域
+func newTestGIF(width, height, count int) []byte {
+	p := color.Palette{
+		color.RGBA{0, 0, 0, 0},
+		color.RGBA{0xff, 0, 0, 0xff},
+		color.RGBA{0, 0xff, 0, 0xff},
+		color.RGBA{0, 0, 0xff, 0xff},
+	}
+	g := &gif.GIF{
+		Config: image.Config{
+			Width:  width,
+			Height: height,
+		},
+	}
+	for i := 0; i < count; i++ {
+		rect := image.Rect(0, 0, width, height)
+		if i != 0 {
+			rect = image.Rect(0, 0, width/2, height/2)
+		}
+		frame := image.NewPaletted(rect, p)
+		for index := range frame.Pix {
+			frame.Pix[index] = uint8(i%3 + 1)
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+	buffer := bytes.Buffer{}
+	_ = gif.EncodeAll(&buffer, g)
+	return buffer.Bytes()
+}
+
+func TestAnimatedGIF(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := storage.NewImageFromBytes(newTestGIF(100, 80, 3))
+	assert.Nil(err)
+	anim, err := img.Animation()
+	assert.Nil(err)
+	assert.Equal(3, len(anim.Frames))
+	// 后续帧与前一帧合成为完整画面
+	r, g, b, _ := anim.Frames[1].At(80, 70).RGBA()
+	assert.Equal([]uint32{0xffff, 0, 0}, []uint32{r, g, b})
+	r, g, b, _ = anim.Frames[1].At(10, 10).RGBA()
+	assert.Equal([]uint32{0, 0xffff, 0}, []uint32{r, g, b})
+
+	img, err = Do(context.Background(), img,
+		NewFitResizeImage(50, 50),
+		NewFlipImage(FlipHorizontal),
+		NewGrayscaleImage(),
+	)
+	assert.Nil(err)
+	assert.Equal(ImageTypeGIF, img.Type)
+
+	result, err := gif.DecodeAll(bytes.NewReader(img.Data))
+	assert.Nil(err)
+	assert.Equal(3, len(result.Image))
+	assert.Equal([]int{10, 10, 10}, result.Delay)
+	for _, frame := range result.Image {
+		assert.Equal(50, frame.Bounds().Dx())
+		assert.Equal(40, frame.Bounds().Dy())
+	}
+}
+
+func TestStaticGIF(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := storage.NewImageFromBytes(newTestGIF(100, 80, 1))
+	assert.Nil(err)
+	anim, err := img.Animation()
+	assert.Nil(err)
+	assert.Nil(anim)
+}
+
+func TestMuxAnimatedWebp(t *testing.T) {
+	assert := assert.New(t)
+
+	// 1x1的无损webp
+	frame := []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+	data, err := muxAnimatedWebp([][]byte{frame, frame}, []int{0, 50}, -1, 1, 1)
+	assert.Nil(err)
+	assert.Equal(len(data)-8, int(uint32(data[4])|uint32(data[5])<<8|uint32(data[6])<<16|uint32(data[7])<<24))
+
+	chunks, err := parseWebpChunks(data)
+	assert.Nil(err)
+	fourCCs := make([]string, 0)
+	for _, chunk := range chunks {
+		fourCCs = append(fourCCs, chunk.fourCC)
+	}
+	assert.Equal([]string{"VP8X", "ANIM", "ANMF", "ANMF"}, fourCCs)
+	assert.Equal(byte(webpFlagAnimation|webpFlagAlpha), chunks[0].data[0])
+	// 不循环时只播放一次
+	assert.Equal([]byte{1, 0}, chunks[1].data[4:])
+	// 时长：延时过短的使用默认值
+	assert.Equal(webpDefaultDuration, getUint24(chunks[2].data[12:]))
+	assert.Equal(500, getUint24(chunks[3].data[12:]))
+
+	frameChunks, err := parseWebpChunks(append([]byte("RIFF\x00\x00\x00\x00WEBP"), chunks[2].data[16:]...))
+	assert.Nil(err)
+	assert.Equal("VP8L", frameChunks[0].fourCC)
+
+	_, err = muxAnimatedWebp(nil, nil, 0, 1, 1)
+	assert.NotNil(err)
+	_, err = muxAnimatedWebp([][]byte{[]byte("abc")}, nil, 0, 1, 1)
+	assert.NotNil(err)
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+// 图片metadata的字段
+const (
+	MetadataCameraMake      = "Camera-Make"
+	MetadataCameraModel     = "Camera-Model"
+	MetadataTakenAt         = "Taken-At"
+	MetadataGPSLatitude     = "Gps-Latitude"
+	MetadataGPSLongitude    = "Gps-Longitude"
+	MetadataExifOrientation = "Exif-Orientation"
+	MetadataDominantColor   = "Dominant-Color"
+	MetadataBlurhash        = "Blurhash"
+)
+
+const (
+	// 主色调的数量
+	dominantColorCount = 3
+	// 计算主色调前先缩小图片
+	dominantColorSampleSize = 64
+)
+
+// ImageMetadataResult 提取metadata的结果
+type ImageMetadataResult struct {
+	Metadata http.Header
+	// 按EXIF的方向调整后的图像，用于获取宽高、主色调等
+	Image image.Image
+	// 图片数据，不重新编码，由EXIF指定显示的方向
+	Data []byte
+}
+
+// exifString 获取EXIF中字符串的值
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Trim(value, "\x00"))
+}
+
+// setExifMetadata 设置EXIF中相机、拍摄时间、GPS以及方向等信息
+func setExifMetadata(metadata http.Header, x *exif.Exif) {
+	if value := exifString(x, exif.Make); value != "" {
+		metadata.Set(MetadataCameraMake, value)
+	}
+	if value := exifString(x, exif.Model); value != "" {
+		metadata.Set(MetadataCameraModel, value)
+	}
+	if takenAt, err := x.DateTime(); err == nil {
+		metadata.Set(MetadataTakenAt, takenAt.Format(time.RFC3339))
+	}
+	if lat, long, err := x.LatLong(); err == nil {
+		metadata.Set(MetadataGPSLatitude, strconv.FormatFloat(lat, 'f', 6, 64))
+		metadata.Set(MetadataGPSLongitude, strconv.FormatFloat(long, 'f', 6, 64))
+	}
+	if orientation := storage.ExifOrientation(x); orientation != 1 {
+		metadata.Set(MetadataExifOrientation, strconv.Itoa(orientation))
+	}
+}
+
+// DominantColors 获取图片的主色调(#rrggbb)，按占比降序，忽略透明的像素
+func DominantColors(img image.Image, count int) []string {
+	sample := imaging.Resize(img, dominantColorSampleSize, dominantColorSampleSize, imaging.Box)
+	type colorBucket struct {
+		key     int
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[int]*colorBucket)
+	pix := sample.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		if pix[i+3] < 128 {
+			continue
+		}
+		r, g, b := int(pix[i]), int(pix[i+1]), int(pix[i+2])
+		// 每个通道量化为16级，相近的颜色归为一类
+		key := (r>>4)<<8 | (g>>4)<<4 | b>>4
+		bucket, ok := buckets[key]
+		if !ok {
+			bucket = &colorBucket{
+				key: key,
+			}
+			buckets[key] = bucket
+		}
+		bucket.count++
+		bucket.r += r
+		bucket.g += g
+		bucket.b += b
+	}
+	items := make([]*colorBucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		items = append(items, bucket)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].count == items[j].count {
+			return items[i].key < items[j].key
+		}
+		return items[i].count > items[j].count
+	})
+	if len(items) > count {
+		items = items[:count]
+	}
+	colors := make([]string, len(items))
+	for index, item := range items {
+		colors[index] = fmt.Sprintf("#%02x%02x%02x", item.r/item.count, item.g/item.count, item.b/item.count)
+	}
+	return colors
+}
+
+// ExtractMetadata 提取图片的EXIF信息(相机、拍摄时间、GPS、方向)、主色调以及blurhash。
+// 图片数据保持原样(保留EXIF与ICC且不损失质量)，仅按EXIF的方向调整图像，
+// 处理图片时解码的图像也会按方向调整
+func ExtractMetadata(data []byte, img image.Image) *ImageMetadataResult {
+	result := &ImageMetadataResult{
+		Metadata: make(http.Header),
+		// 与处理图片时一致，仅jpeg按EXIF的方向调整
+		Image: storage.ApplyOrientation(img, storage.ImageOrientation(data)),
+		Data:  data,
+	}
+	// 仅jpeg与tiff包含EXIF，解析失败则忽略
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err == nil {
+		setExifMetadata(result.Metadata, x)
+	}
+	for _, color := range DominantColors(result.Image, dominantColorCount) {
+		result.Metadata.Add(MetadataDominantColor, color)
+	}
+	result.Metadata.Set(MetadataBlurhash, EncodeBlurhash(result.Image))
+	return result
+}
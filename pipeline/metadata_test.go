@@ -0,0 +1,203 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+type testIFDEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+// buildTestIFD 生成从start开始的IFD，超过4字节的数据紧跟在IFD之后
+func buildTestIFD(start uint32, entries []testIFDEntry) []byte {
+	ifd := &bytes.Buffer{}
+	extra := &bytes.Buffer{}
+	dataOffset := start + uint32(2+12*len(entries)+4)
+	_ = binary.Write(ifd, binary.LittleEndian, uint16(len(entries)))
+	for _, entry := range entries {
+		_ = binary.Write(ifd, binary.LittleEndian, entry.tag)
+		_ = binary.Write(ifd, binary.LittleEndian, entry.typ)
+		_ = binary.Write(ifd, binary.LittleEndian, entry.count)
+		if len(entry.data) <= 4 {
+			value := make([]byte, 4)
+			copy(value, entry.data)
+			ifd.Write(value)
+			continue
+		}
+		_ = binary.Write(ifd, binary.LittleEndian, dataOffset+uint32(extra.Len()))
+		extra.Write(entry.data)
+		if extra.Len()%2 != 0 {
+			extra.WriteByte(0)
+		}
+	}
+	_ = binary.Write(ifd, binary.LittleEndian, uint32(0))
+	ifd.Write(extra.Bytes())
+	return ifd.Bytes()
+}
+
+func testRational(values ...uint32) []byte {
+	buf := &bytes.Buffer{}
+	for _, value := range values {
+		_ = binary.Write(buf, binary.LittleEndian, value)
+		_ = binary.Write(buf, binary.LittleEndian, uint32(1))
+	}
+	return buf.Bytes()
+}
+
+func testUint(size int, value uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, value)
+	return buf[:size]
+}
+
+// newTestExif 生成包含相机、拍摄时间、方向以及GPS信息的EXIF
+func newTestExif(orientation int) []byte {
+	ifd0 := func(gpsOffset uint32) []testIFDEntry {
+		return []testIFDEntry{
+			// Make
+			{0x010f, 2, 5, []byte("Test\x00")},
+			// Orientation
+			{0x0112, 3, 1, testUint(2, uint32(orientation))},
+			// DateTime
+			{0x0132, 2, 20, []byte("2022:01:02 03:04:05\x00")},
+			// GPSInfo
+			{0x8825, 4, 1, testUint(4, gpsOffset)},
+		}
+	}
+	// 先计算IFD0的长度以获取GPS IFD的位置
+	gpsOffset := uint32(8 + len(buildTestIFD(8, ifd0(0))))
+	gps := buildTestIFD(gpsOffset, []testIFDEntry{
+		{0x0001, 2, 2, []byte("N\x00")},
+		{0x0002, 5, 3, testRational(22, 30, 0)},
+		{0x0003, 2, 2, []byte("E\x00")},
+		{0x0004, 5, 3, testRational(114, 15, 0)},
+	})
+	buf := bytes.NewBufferString("Exif\x00\x00II")
+	_ = binary.Write(buf, binary.LittleEndian, uint16(0x2a))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(8))
+	buf.Write(buildTestIFD(8, ifd0(gpsOffset)))
+	buf.Write(gps)
+	return buf.Bytes()
+}
+
+// newTestJPEG 生成宽为height两倍的jpeg，包含exif则插入至SOI之后
+func newTestJPEG(t *testing.T, height int, exifData []byte) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 2*height, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{R: 255, A: 255}}, image.Point{}, draw.Src)
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if len(exifData) == 0 {
+		return data
+	}
+	result := &bytes.Buffer{}
+	result.Write(data[:2])
+	result.Write([]byte{0xff, 0xe1})
+	_ = binary.Write(result, binary.BigEndian, uint16(len(exifData)+2))
+	result.Write(exifData)
+	result.Write(data[2:])
+	return result.Bytes()
+}
+
+func TestEncodeBlurhash(t *testing.T) {
+	assert := assert.New(t)
+
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{R: 255, A: 255}}, image.Point{}, draw.Src)
+	// 4x3个分量，长度为1+1+4+11*2
+	hash := EncodeBlurhash(img)
+	assert.Equal(28, len(hash))
+	assert.Equal("L", hash[:1])
+	// 直流分量为平均色(纯红)
+	assert.Equal("TI:j", hash[2:6])
+
+	// 左右两种颜色，平均色不同
+	draw.Draw(img, image.Rect(10, 0, 20, 10), &image.Uniform{color.RGBA{B: 255, A: 255}}, image.Point{}, draw.Src)
+	result := EncodeBlurhash(img)
+	assert.Equal(28, len(result))
+	assert.NotEqual(hash[2:6], result[2:6])
+}
+
+func TestDominantColors(t *testing.T) {
+	assert := assert.New(t)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.NRGBA{R: 255, A: 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 64, 16), &image.Uniform{color.NRGBA{B: 255, A: 255}}, image.Point{}, draw.Src)
+	// 透明的像素忽略
+	draw.Draw(img, image.Rect(0, 16, 64, 20), &image.Uniform{color.NRGBA{G: 255}}, image.Point{}, draw.Src)
+	assert.Equal([]string{"#ff0000", "#0000ff"}, DominantColors(img, 3))
+	assert.Equal([]string{"#ff0000"}, DominantColors(img, 1))
+}
+
+func TestExtractMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	// 无EXIF
+	data := newTestJPEG(t, 10, nil)
+	img, _, err := image.Decode(bytes.NewReader(data))
+	assert.Nil(err)
+	result := ExtractMetadata(data, img)
+	assert.Equal(data, result.Data)
+	assert.Empty(result.Metadata.Get(MetadataCameraMake))
+	assert.Equal(1, len(result.Metadata.Values(MetadataDominantColor)))
+	assert.NotEmpty(result.Metadata.Get(MetadataBlurhash))
+
+	// 方向为6(顺时针旋转90度)
+	data = newTestJPEG(t, 10, newTestExif(6))
+	img, _, err = image.Decode(bytes.NewReader(data))
+	assert.Nil(err)
+	result = ExtractMetadata(data, img)
+	assert.Equal("Test", result.Metadata.Get(MetadataCameraMake))
+	// 无时区信息时为本地时间
+	assert.True(strings.HasPrefix(result.Metadata.Get(MetadataTakenAt), "2022-01-02T03:04:05"))
+	assert.Equal("22.500000", result.Metadata.Get(MetadataGPSLatitude))
+	assert.Equal("114.250000", result.Metadata.Get(MetadataGPSLongitude))
+	assert.Equal("6", result.Metadata.Get(MetadataExifOrientation))
+	assert.Equal(10, result.Image.Bounds().Dx())
+	assert.Equal(20, result.Image.Bounds().Dy())
+	// 数据不重新编码，处理图片时按EXIF的方向调整
+	assert.Equal(data, result.Data)
+	storageImage, err := storage.NewImageFromBytes(result.Data)
+	assert.Nil(err)
+	assert.Equal(10, storageImage.Width)
+	assert.Equal(20, storageImage.Height)
+	storageImage, err = Do(context.Background(), storageImage, NewGrayscaleImage())
+	assert.Nil(err)
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(storageImage.Data))
+	assert.Nil(err)
+	assert.Equal(10, cfg.Width)
+	assert.Equal(20, cfg.Height)
+	assert.Equal(1, storage.ImageOrientation(storageImage.Data))
+}
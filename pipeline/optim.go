@@ -36,31 +36,99 @@ func mustNewTinyConnection() *grpc.ClientConn {
 	return conn
 }
 
+// tinyTypes tiny支持的图片格式
+var tinyTypes = map[string]pb.Type{
+	ImageTypePNG:  pb.Type_PNG,
+	ImageTypeJPEG: pb.Type_JPEG,
+	ImageTypeWEBP: pb.Type_WEBP,
+	ImageTypeAVIF: pb.Type_AVIF,
+}
+
+// optimAnimatedWebp 将动图转换为动态webp，
+// tiny不支持动图，因此每帧单独转换为webp后再合并
+func optimAnimatedWebp(ctx context.Context, img *storage.Image, anim *storage.Animation, quality int) (*storage.Image, error) {
+	client := pb.NewOptimClient(tinyConn)
+	frames := make([][]byte, len(anim.Frames))
+	for index, frame := range anim.Frames {
+		data, _, err := encodeImage(frame, ImageTypePNG)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := client.DoOptim(ctx, &pb.OptimRequest{
+			Data:    data,
+			Quality: uint32(quality),
+			Source:  pb.Type_PNG,
+			Output:  pb.Type_WEBP,
+		})
+		if err != nil {
+			return nil, err
+		}
+		frames[index] = reply.Data
+	}
+	data, err := muxAnimatedWebp(frames, anim.Delays, anim.LoopCount, img.Width, img.Height)
+	if err != nil {
+		return nil, err
+	}
+	img.Type = ImageTypeWEBP
+	img.SetData(data)
+	return img, nil
+}
+
 func optim(ctx context.Context, img *storage.Image, quality int, format string) (*storage.Image, error) {
+	anim, err := img.Animation()
+	if err != nil {
+		return nil, err
+	}
+	if anim != nil {
+		switch format {
+		case ImageTypeGIF:
+			// tiny不支持gif，动图仅重新编码
+			err = encodeImageIfChanged(img)
+			if err != nil {
+				return nil, err
+			}
+			return img, nil
+		case ImageTypeWEBP:
+			return optimAnimatedWebp(ctx, img, anim, quality)
+		}
+		// 其它格式仅保留第一帧
+		img.ClearAnimation()
+	}
+	// tiny不支持的格式(gif、bmp等)先转换为png
+	if _, ok := tinyTypes[img.Type]; !ok {
+		srcImage, err := decodeImage(img)
+		if err != nil {
+			return nil, err
+		}
+		img.SetImage(srcImage)
+		img.Type = ImageTypePNG
+	}
+	// tiny不保留EXIF，未调整的图像需按EXIF的方向重新编码
+	if !img.Changed() && storage.ImageOrientation(img.Data) != 1 {
+		srcImage, err := decodeImage(img)
+		if err != nil {
+			return nil, err
+		}
+		img.SetImage(srcImage)
+	}
+	// 调整后的图像需要先编码再压缩
+	err = encodeImageIfChanged(img)
+	if err != nil {
+		return nil, err
+	}
+	source := tinyTypes[img.Type]
+	output, ok := tinyTypes[format]
+	// 不支持的输出格式使用jpeg
+	if !ok {
+		format = ImageTypeJPEG
+		output = pb.Type_JPEG
+	}
 	client := pb.NewOptimClient(tinyConn)
 	in := pb.OptimRequest{
 		Data:    img.Data,
 		Quality: uint32(quality),
-	}
-	switch format {
-	case ImageTypePNG:
-		in.Output = pb.Type_PNG
-	case ImageTypeWEBP:
-		in.Output = pb.Type_WEBP
-	case ImageTypeAVIF:
-		in.Output = pb.Type_AVIF
-	default:
-		in.Output = pb.Type_JPEG
-	}
-	switch img.Type {
-	case ImageTypePNG:
-		in.Source = pb.Type_PNG
-	case ImageTypeWEBP:
-		in.Source = pb.Type_WEBP
-	case ImageTypeAVIF:
-		in.Output = pb.Type_AVIF
-	default:
-		in.Source = pb.Type_JPEG
+		Source:  source,
+		Output:  output,
 	}
 	reply, err := client.DoOptim(ctx, &in)
 	if err != nil {
@@ -77,14 +145,23 @@ func NewAutoOptimImage(quality int, header http.Header) ImageJob {
 		accept := header.Get("Accept")
 		acceptWebp := strings.Contains(accept, "image/webp")
 		acceptAvif := strings.Contains(accept, "image/avif")
+		anim, err := img.Animation()
+		if err != nil {
+			return nil, err
+		}
+		// 动图不转换为avif，支持webp则转换为动态webp，否则保持gif
+		if anim != nil {
+			acceptAvif = false
+		}
 
 		if acceptAvif {
 			format = ImageTypeAVIF
 		} else if acceptWebp {
-			format = ImageTypeWEBP
+			// png转换为webp时使用无损压缩
 			if format == ImageTypePNG {
 				quality = 0
 			}
+			format = ImageTypeWEBP
 		}
 		return optim(ctx, img, quality, format)
 	}
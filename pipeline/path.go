@@ -0,0 +1,115 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"strings"
+
+	"github.com/vicanso/hes"
+)
+
+const (
+	// 路径中表示不做任何处理
+	PathNoOps = "_"
+	// 路径中任务的分隔符
+	pathTaskSeparator = "|"
+	// 路径中任务参数的分隔符
+	pathParamSeparator = ","
+	// 路径中未指定压缩质量时使用的默认值
+	pathDefaultQuality = "80"
+)
+
+// 无参数的任务，路径中可直接使用，其它无分隔符的均认为是预设
+var noParamTasks = map[string]bool{
+	"grayscale": true,
+	"invert":    true,
+}
+
+// 扩展名对应的图片格式
+var pathFormats = map[string]string{
+	"jpg":  ImageTypeJPEG,
+	"jpeg": ImageTypeJPEG,
+	"png":  ImageTypePNG,
+	"webp": ImageTypeWEBP,
+	"avif": ImageTypeAVIF,
+}
+
+// GetPathFormat 根据扩展名获取输出的图片格式
+func GetPathFormat(ext string) (string, error) {
+	format, ok := pathFormats[strings.ToLower(ext)]
+	if !ok {
+		return "", hes.New("extension of image is not supported")
+	}
+	return format, nil
+}
+
+// withOutputFormat 设置输出格式，已有压缩任务的调整其格式，否则添加压缩任务
+func withOutputFormat(tasks []string, format string) []string {
+	result := make([]string, 0, len(tasks)+1)
+	found := false
+	for _, task := range tasks {
+		arr := strings.Split(task, "/")
+		switch arr[0] {
+		case "optim", "autoOptim":
+			quality := pathDefaultQuality
+			if len(arr) > 1 && arr[1] != "" {
+				quality = arr[1]
+			}
+			task = "optim/" + quality + "/" + format
+			found = true
+		}
+		result = append(result, task)
+	}
+	if !found {
+		result = append(result, "optim/"+pathDefaultQuality+"/"+format)
+	}
+	return result
+}
+
+// ParsePathTasks 将路径形式的参数转换为任务列表。
+// ops为预设名称，或以|分隔任务、以,分隔参数的任务列表(如fitResize,300,300|grayscale)，
+// _表示不做处理，ext为输出图片的扩展名
+func ParsePathTasks(ctx context.Context, bucket, ops, name, ext string) ([]string, error) {
+	format, err := GetPathFormat(ext)
+	if err != nil {
+		return nil, err
+	}
+	tasks := []string{
+		"bucket/" + bucket + "/" + name,
+	}
+	switch {
+	case ops == PathNoOps:
+	case strings.Contains(ops, pathTaskSeparator) ||
+		strings.Contains(ops, pathParamSeparator) ||
+		noParamTasks[ops]:
+		for _, task := range NormalizeTasks(strings.Split(ops, pathTaskSeparator)) {
+			tasks = append(tasks, strings.ReplaceAll(task, pathParamSeparator, "/"))
+		}
+	default:
+		presetTasks, err := getPresetTasks(ctx, bucket, ops)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, presetTasks...)
+	}
+	for _, task := range tasks[1:] {
+		switch strings.Split(task, "/")[0] {
+		case "bucket", taskPreset, taskSign, taskSave:
+			return nil, hes.New("ops of path can not contain bucket, preset, sign or save")
+		}
+	}
+	return withOutputFormat(tasks, format), nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePathTasks(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	presetCache.Add("test/avatar", []string{"fillResize/64/64", "autoOptim/70"})
+	defer RemovePresetCache("test", "avatar")
+
+	tests := []struct {
+		ops    string
+		ext    string
+		result []string
+	}{
+		{
+			ops: PathNoOps,
+			ext: "jpg",
+			result: []string{
+				"bucket/test/abc",
+				"optim/80/jpeg",
+			},
+		},
+		{
+			ops: "fitResize,300,300|grayscale",
+			ext: "webp",
+			result: []string{
+				"bucket/test/abc",
+				"fitResize/300/300",
+				"grayscale",
+				"optim/80/webp",
+			},
+		},
+		{
+			ops: "invert",
+			ext: "PNG",
+			result: []string{
+				"bucket/test/abc",
+				"invert",
+				"optim/80/png",
+			},
+		},
+		// 预设中的压缩任务使用扩展名对应的格式
+		{
+			ops: "avatar",
+			ext: "avif",
+			result: []string{
+				"bucket/test/abc",
+				"fillResize/64/64",
+				"optim/70/avif",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tasks, err := ParsePathTasks(ctx, "test", tt.ops, "abc", tt.ext)
+		assert.Nil(err)
+		assert.Equal(tt.result, tasks)
+	}
+
+	_, err := ParsePathTasks(ctx, "test", PathNoOps, "abc", "bmp")
+	assert.NotNil(err)
+	_, err = ParsePathTasks(ctx, "test", "bucket,other,abc", "abc", "png")
+	assert.NotNil(err)
+	_, err = ParsePathTasks(ctx, "test", "not-found", "abc", "png")
+	assert.NotNil(err)
+}
@@ -18,7 +18,9 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"image"
+	"image/color"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -46,13 +48,29 @@ const (
 	ImageTypeJPEG = "jpeg"
 	ImageTypeWEBP = "webp"
 	ImageTypeAVIF = "avif"
+	ImageTypeGIF  = "gif"
+	ImageTypeBMP  = "bmp"
+	ImageTypeTIFF = "tiff"
 )
 
+// imagingFormats 可直接编码的图片格式，webp与avif仅能通过tiny生成
+var imagingFormats = map[string]imaging.Format{
+	ImageTypePNG:  imaging.PNG,
+	ImageTypeJPEG: imaging.JPEG,
+	ImageTypeGIF:  imaging.GIF,
+	ImageTypeBMP:  imaging.BMP,
+	ImageTypeTIFF: imaging.TIFF,
+}
+
 // 不再执行后续时返回
 var ErrAbort = errors.New("abort")
 
+// 任务列表未获取图片时返回
+var ErrNoImageSource = hes.New("pipeline has no image source")
+
 type ImageJob func(context.Context, *storage.Image) (*storage.Image, error)
 
+// Do 依次执行任务，图像仅在全部任务完成后（或optim前）才重新编码
 func Do(ctx context.Context, img *storage.Image, jobs ...ImageJob) (*storage.Image, error) {
 	var err error
 	for _, fn := range jobs {
@@ -60,11 +78,22 @@ func Do(ctx context.Context, img *storage.Image, jobs ...ImageJob) (*storage.Ima
 		if err != nil {
 			// 如果是abort error，则直接返回数据
 			if err == ErrAbort {
-				return img, nil
+				break
 			}
 			return nil, err
 		}
 	}
+	if img == nil {
+		return nil, ErrNoImageSource
+	}
+	err = encodeImageIfChanged(img)
+	if err != nil {
+		return nil, err
+	}
+	err = stripImageMetadata(img)
+	if err != nil {
+		return nil, err
+	}
 	return img, nil
 }
 
@@ -116,7 +145,215 @@ func parseFillResize(params []string, _ http.Header) (ImageJob, error) {
 	}
 	width, _ := strconv.Atoi(params[1])
 	height, _ := strconv.Atoi(params[2])
-	return NewFillResizeImage(width, height), nil
+	gravity := PositionCenter
+	if len(params) > 3 && params[3] != "" {
+		gravity = params[3]
+	}
+	return NewFillResizeImage(width, height, gravity), nil
+}
+
+// parseCrop 裁剪参数：crop/x/y/width/height
+func parseCrop(params []string, _ http.Header) (ImageJob, error) {
+	if len(params) < 5 {
+		return nil, hes.New("crop params is invalid")
+	}
+	values := make([]int, 4)
+	for index, v := range params[1:5] {
+		value, err := strconv.Atoi(v)
+		if err != nil || value < 0 {
+			return nil, hes.New("crop params is invalid")
+		}
+		values[index] = value
+	}
+	if values[2] == 0 || values[3] == 0 {
+		return nil, hes.New("width and height of crop can not be 0")
+	}
+	return NewCropImage(values[0], values[1], values[2], values[3]), nil
+}
+
+// parseRotate 旋转参数：rotate/angle
+func parseRotate(params []string, _ http.Header) (ImageJob, error) {
+	if len(params) < 2 {
+		return nil, hes.New("rotate params is invalid")
+	}
+	angle, err := strconv.ParseFloat(params[1], 64)
+	if err != nil {
+		return nil, hes.New("rotate params is invalid")
+	}
+	return NewRotateImage(angle), nil
+}
+
+// parseFlip 翻转参数：flip/h或flip/v
+func parseFlip(params []string, _ http.Header) (ImageJob, error) {
+	if len(params) < 2 {
+		return nil, hes.New("flip params is invalid")
+	}
+	switch params[1] {
+	case FlipHorizontal, FlipVertical:
+	default:
+		return nil, hes.New("flip direction should be h or v")
+	}
+	return NewFlipImage(params[1]), nil
+}
+
+// parseWatermark 水印参数：watermark/source/position/angle/opacity/margin
+func parseWatermark(params []string, _ http.Header) (ImageJob, error) {
+	if len(params) < 2 {
+		return nil, hes.New("watermark params is invalid")
+	}
+	source, err := url.QueryUnescape(params[1])
+	if err != nil {
+		return nil, err
+	}
+	position := PositionBottomRight
+	if len(params) > 2 && params[2] != "" {
+		position = params[2]
+	}
+	angle := 0.0
+	if len(params) > 3 {
+		angle, _ = strconv.ParseFloat(params[3], 64)
+	}
+	opacity := 1.0
+	if len(params) > 4 {
+		opacity, err = strconv.ParseFloat(params[4], 64)
+		if err != nil || opacity < 0 || opacity > 1 {
+			return nil, hes.New("watermark opacity should be 0-1")
+		}
+	}
+	margin := 0
+	if len(params) > 5 {
+		margin, _ = strconv.Atoi(params[5])
+	}
+	return NewWatermark(source, position, angle, opacity, margin), nil
+}
+
+// parseTextWatermark 文字水印参数：textWatermark/text/fontSize/color/opacity/position/repeat/margin
+func parseTextWatermark(params []string, _ http.Header) (ImageJob, error) {
+	if len(params) < 2 {
+		return nil, hes.New("text watermark params is invalid")
+	}
+	text, err := url.QueryUnescape(params[1])
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, hes.New("text of watermark can not be empty")
+	}
+	opt := TextWatermarkOption{
+		Text:     text,
+		FontSize: 16,
+		Color: color.NRGBA{
+			R: 0xff,
+			G: 0xff,
+			B: 0xff,
+			A: 0xff,
+		},
+		Opacity:  1,
+		Position: PositionBottomRight,
+	}
+	if len(params) > 2 && params[2] != "" {
+		opt.FontSize, err = strconv.ParseFloat(params[2], 64)
+		if err != nil ||
+			opt.FontSize < TextWatermarkMinFontSize ||
+			opt.FontSize > TextWatermarkMaxFontSize {
+			return nil, hes.New(fmt.Sprintf("font size of text watermark should be %d-%d", TextWatermarkMinFontSize, TextWatermarkMaxFontSize))
+		}
+	}
+	if len(params) > 3 && params[3] != "" {
+		opt.Color, err = parseHexColor(params[3])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(params) > 4 && params[4] != "" {
+		opt.Opacity, err = strconv.ParseFloat(params[4], 64)
+		if err != nil || opt.Opacity < 0 || opt.Opacity > 1 {
+			return nil, hes.New("watermark opacity should be 0-1")
+		}
+	}
+	if len(params) > 5 && params[5] != "" {
+		opt.Position = params[5]
+	}
+	if len(params) > 6 {
+		opt.Repeat = params[6]
+		switch opt.Repeat {
+		case TextRepeatNone, TextRepeatTiled, TextRepeatDiagonal:
+		default:
+			return nil, hes.New("repeat of text watermark should be tiled or diagonal")
+		}
+	}
+	if len(params) > 7 {
+		opt.Margin, _ = strconv.Atoi(params[7])
+	}
+	return NewTextWatermark(opt), nil
+}
+
+// parseFloatParam 获取任务的第一个参数并转换为float，需在min与max之间
+func parseFloatParam(params []string, min, max float64) (float64, error) {
+	if len(params) < 2 {
+		return 0, hes.New(params[0] + " params is invalid")
+	}
+	value, err := strconv.ParseFloat(params[1], 64)
+	if err != nil || value < min || value > max {
+		return 0, hes.New(fmt.Sprintf("%s params should be %v-%v", params[0], min, max))
+	}
+	return value, nil
+}
+
+func parseBlur(params []string, _ http.Header) (ImageJob, error) {
+	sigma, err := parseFloatParam(params, 0.1, 50)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlurImage(sigma), nil
+}
+
+func parseSharpen(params []string, _ http.Header) (ImageJob, error) {
+	sigma, err := parseFloatParam(params, 0.1, 50)
+	if err != nil {
+		return nil, err
+	}
+	return NewSharpenImage(sigma), nil
+}
+
+func parseGrayscale(_ []string, _ http.Header) (ImageJob, error) {
+	return NewGrayscaleImage(), nil
+}
+
+func parseInvert(_ []string, _ http.Header) (ImageJob, error) {
+	return NewInvertImage(), nil
+}
+
+func parseBrightness(params []string, _ http.Header) (ImageJob, error) {
+	percentage, err := parseFloatParam(params, -100, 100)
+	if err != nil {
+		return nil, err
+	}
+	return NewBrightnessImage(percentage), nil
+}
+
+func parseContrast(params []string, _ http.Header) (ImageJob, error) {
+	percentage, err := parseFloatParam(params, -100, 100)
+	if err != nil {
+		return nil, err
+	}
+	return NewContrastImage(percentage), nil
+}
+
+func parseSaturation(params []string, _ http.Header) (ImageJob, error) {
+	percentage, err := parseFloatParam(params, -100, 500)
+	if err != nil {
+		return nil, err
+	}
+	return NewSaturationImage(percentage), nil
+}
+
+func parseGamma(params []string, _ http.Header) (ImageJob, error) {
+	gamma, err := parseFloatParam(params, 0.1, 10)
+	if err != nil {
+		return nil, err
+	}
+	return NewGammaImage(gamma), nil
 }
 
 func parseBucket(params []string, _ http.Header) (ImageJob, error) {
@@ -139,16 +376,48 @@ func parseFinder(params []string, _ http.Header) (ImageJob, error) {
 	}, nil
 }
 
+// isSourceTask 是否为获取图片的任务(bucket、proxy以及storage的finder)
+func isSourceTask(name string) bool {
+	switch name {
+	case "bucket", "proxy":
+		return true
+	}
+	_, err := storage.GetFinder(name)
+	return err == nil
+}
+
+// Parse 解析任务列表，第一个任务需为获取图片的任务
 func Parse(tasks []string, header http.Header) ([]ImageJob, error) {
+	jobs, sourced, err := parseTasks(tasks, header)
+	if err != nil {
+		return nil, err
+	}
+	if !sourced {
+		return nil, ErrNoImageSource
+	}
+	return jobs, nil
+}
+
+// parseTasks 解析任务列表，并返回第一个任务是否为获取图片的任务
+func parseTasks(tasks []string, header http.Header) ([]ImageJob, bool, error) {
 	jobs := make([]ImageJob, 0)
-	for _, v := range tasks {
+	sourced := false
+	for index, v := range tasks {
 		var fn Parser
+		isSource := false
 		arr := strings.Split(v, "/")
 		switch arr[0] {
+		case taskSave:
+			if index != len(tasks)-1 {
+				return nil, false, hes.New("save should be the last task")
+			}
+			fn = parseSave
 		case "bucket":
 			fn = parseBucket
+			isSource = true
 		case "proxy":
 			fn = parseProxy
+			isSource = true
 		case "optim":
 			fn = parseOptim
 		case "autoOptim":
@@ -157,42 +426,125 @@ func Parse(tasks []string, header http.Header) ([]ImageJob, error) {
 			fn = parseFitResize
 		case "fillResize":
 			fn = parseFillResize
+		case "crop":
+			fn = parseCrop
+		case "rotate":
+			fn = parseRotate
+		case "flip":
+			fn = parseFlip
+		case "blur":
+			fn = parseBlur
+		case "sharpen":
+			fn = parseSharpen
+		case "grayscale":
+			fn = parseGrayscale
+		case "invert":
+			fn = parseInvert
+		case "brightness":
+			fn = parseBrightness
+		case "contrast":
+			fn = parseContrast
+		case "saturation":
+			fn = parseSaturation
+		case "gamma":
+			fn = parseGamma
+		case "watermark":
+			fn = parseWatermark
+		case "textWatermark":
+			fn = parseTextWatermark
 		default:
 			// 从storage中加载图片
 			fn = parseFinder
+			isSource = true
+		}
+		if index == 0 {
+			sourced = isSource
 		}
 		if fn == nil {
 			continue
 		}
 		job, err := fn(arr, header)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		jobs = append(jobs, job)
 	}
-	return jobs, nil
+	return jobs, sourced, nil
 }
 
+// decodeImage 获取图像，如果已解码则直接使用
 func decodeImage(img *storage.Image) (image.Image, error) {
-	if len(img.Data) == 0 {
+	if len(img.Data) == 0 && !img.Changed() {
 		return nil, hes.New("data of image can not be empty")
 	}
-	srcImage, _, err := image.Decode(bytes.NewReader(img.Data))
+	srcImage, err := img.Image()
 	if err != nil {
+		if img.Type == ImageTypeAVIF {
+			return nil, hes.New("avif can not be decoded, convert it by optim first")
+		}
 		return nil, err
 	}
 	return srcImage, nil
 }
 
-func encodeImage(img image.Image, format string) ([]byte, error) {
-	buffer := bytes.Buffer{}
-	f := imaging.JPEG
-	if format == ImageTypePNG {
-		f = imaging.PNG
+// encodeImageIfChanged 如果图像有调整，则重新编码更新数据
+func encodeImageIfChanged(img *storage.Image) error {
+	if !img.Changed() {
+		return nil
+	}
+	anim, err := img.Animation()
+	if err != nil {
+		return err
 	}
+	// 动图保持gif格式
+	if anim != nil {
+		data, err := encodeAnimation(anim)
+		if err != nil {
+			return err
+		}
+		img.Type = ImageTypeGIF
+		img.SetData(data)
+		return nil
+	}
+	srcImage, err := img.Image()
+	if err != nil {
+		return err
+	}
+	data, format, err := encodeImage(srcImage, img.Type)
+	if err != nil {
+		return err
+	}
+	img.Type = format
+	img.SetData(data)
+	return nil
+}
+
+// isOpaque 判断图像是否不透明，无法判断的均认为不透明
+func isOpaque(img image.Image) bool {
+	o, ok := img.(interface {
+		Opaque() bool
+	})
+	if !ok {
+		return true
+	}
+	return o.Opaque()
+}
+
+// encodeImage 按指定格式编码，返回数据与实际的格式。
+// 不支持编码的格式(webp、avif)，有透明的使用png，否则使用jpeg
+func encodeImage(img image.Image, format string) ([]byte, string, error) {
+	f, ok := imagingFormats[format]
+	if !ok {
+		format = ImageTypeJPEG
+		if !isOpaque(img) {
+			format = ImageTypePNG
+		}
+		f = imagingFormats[format]
+	}
+	buffer := bytes.Buffer{}
 	err := imaging.Encode(&buffer, img, f)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return buffer.Bytes(), nil
+	return buffer.Bytes(), format, nil
 }
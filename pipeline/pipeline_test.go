@@ -0,0 +1,190 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+// newBenchmarkJPEG 生成渐变的jpeg图片
+func newBenchmarkJPEG(width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(x * 255 / width),
+				G: uint8(y * 255 / height),
+				B: uint8((x + y) % 256),
+				A: 0xff,
+			})
+		}
+	}
+	buffer := bytes.Buffer{}
+	_ = jpeg.Encode(&buffer, img, nil)
+	return buffer.Bytes()
+}
+
+func newBenchmarkJobs() []ImageJob {
+	return []ImageJob{
+		NewCropImage(0, 0, 1500, 1000),
+		NewFitResizeImage(1000, 1000),
+		NewBrightnessImage(10),
+		NewSharpenImage(0.5),
+		NewFillResizeImage(600, 400, PositionCenter),
+	}
+}
+
+func TestDoEncodeOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := storage.NewImageFromBytes(newBenchmarkJPEG(400, 300))
+	assert.Nil(err)
+	data := img.Data
+	img, err = Do(context.Background(), img,
+		NewCropImage(0, 0, 200, 200),
+		NewGrayscaleImage(),
+	)
+	assert.Nil(err)
+	assert.False(img.Changed())
+	assert.NotEqual(data, img.Data)
+	assert.Equal(len(img.Data), img.Size)
+
+	result, err := storage.NewImageFromBytes(img.Data)
+	assert.Nil(err)
+	assert.Equal(200, result.Width)
+	assert.Equal(200, result.Height)
+}
+
+func TestNoImageSource(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Parse([]string{"fitResize/64/64", "bucket/test/a"}, http.Header{})
+	assert.Equal(ErrNoImageSource, err)
+	_, err = Parse(nil, http.Header{})
+	assert.Equal(ErrNoImageSource, err)
+	_, err = Parse([]string{"proxy/https%3A%2F%2Fexample.com%2Fa.png", "fitResize/64/64"}, http.Header{})
+	assert.Nil(err)
+
+	_, err = Do(context.Background(), nil)
+	assert.Equal(ErrNoImageSource, err)
+}
+
+func TestEncodeImage(t *testing.T) {
+	assert := assert.New(t)
+
+	opaque := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw := func(img *image.NRGBA, alpha uint8) {
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = alpha
+		}
+	}
+	draw(opaque, 0xff)
+	transparent := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw(transparent, 0x80)
+
+	for _, format := range []string{
+		ImageTypePNG,
+		ImageTypeJPEG,
+		ImageTypeGIF,
+		ImageTypeBMP,
+		ImageTypeTIFF,
+	} {
+		data, result, err := encodeImage(opaque, format)
+		assert.Nil(err)
+		assert.Equal(format, result)
+		img, err := storage.NewImageFromBytes(data)
+		assert.Nil(err)
+		assert.Equal(format, img.Type)
+	}
+
+	// 不支持编码的格式
+	_, result, err := encodeImage(opaque, ImageTypeWEBP)
+	assert.Nil(err)
+	assert.Equal(ImageTypeJPEG, result)
+	_, result, err = encodeImage(transparent, ImageTypeAVIF)
+	assert.Nil(err)
+	assert.Equal(ImageTypePNG, result)
+}
+
+func TestWebpResize(t *testing.T) {
+	assert := assert.New(t)
+
+	// 1x1的无损webp
+	buf, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	img, err := storage.NewImageFromBytes(buf)
+	assert.Nil(err)
+	assert.Equal(ImageTypeWEBP, img.Type)
+
+	img, err = Do(context.Background(), img, NewRotateImage(90))
+	assert.Nil(err)
+	// webp无法直接编码，类型需要与数据一致
+	result, err := storage.NewImageFromBytes(img.Data)
+	assert.Nil(err)
+	assert.Equal(result.Type, img.Type)
+	assert.NotEqual(ImageTypeWEBP, img.Type)
+}
+
+// BenchmarkPipelineEncodeEachJob 每个任务均解码与编码（调整前的处理方式）
+func BenchmarkPipelineEncodeEachJob(b *testing.B) {
+	data := newBenchmarkJPEG(2000, 1500)
+	jobs := newBenchmarkJobs()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img, err := storage.NewImageFromBytes(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, job := range jobs {
+			img, err = job(context.Background(), img)
+			if err != nil {
+				b.Fatal(err)
+			}
+			// SetData会清除已解码的图像，下一任务需重新解码
+			err = encodeImageIfChanged(img)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkPipelineEncodeOnce 仅解码与编码一次
+func BenchmarkPipelineEncodeOnce(b *testing.B) {
+	data := newBenchmarkJPEG(2000, 1500)
+	jobs := newBenchmarkJobs()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img, err := storage.NewImageFromBytes(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = Do(context.Background(), img, jobs...)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/cache"
+	"github.com/vicanso/tiny-site/ent"
+	entPreset "github.com/vicanso/tiny-site/ent/preset"
+	"github.com/vicanso/tiny-site/helper"
+)
+
+// 预设任务的名称，格式为preset/name
+const taskPreset = "preset"
+
+// 预设的缓存，预设调整后最多一分钟后生效
+var presetCache = cache.NewLRUCache(1000, time.Minute)
+
+// ValidatePresetTasks 校验预设的任务列表，预设中不可再引用预设、签名或保存，
+// 也不可获取图片(bucket、proxy或storage)以及使用外部或其它bucket的水印，
+// 避免预设调整后已签名的pipeline可访问其它的图片
+func ValidatePresetTasks(bucket string, tasks []string) error {
+	if len(tasks) == 0 {
+		return hes.New("tasks of preset can not be empty")
+	}
+	for _, task := range tasks {
+		arr := strings.Split(task, "/")
+		switch arr[0] {
+		case taskPreset, taskSign, taskSave:
+			return hes.New("preset can not contain preset, sign or save")
+		case "watermark":
+			if len(arr) < 2 {
+				break
+			}
+			source, _ := url.QueryUnescape(arr[1])
+			if strings.SplitN(source, ":", 2)[0] != bucket {
+				return hes.New("watermark of preset should be the image of the same bucket")
+			}
+		default:
+			if isSourceTask(arr[0]) {
+				return hes.New("preset can not contain bucket, proxy or storage")
+			}
+		}
+	}
+	// 预设可仅包含调整图片的任务
+	_, _, err := parseTasks(tasks, http.Header{})
+	return err
+}
+
+func getPresetTasks(ctx context.Context, bucket, name string) ([]string, error) {
+	key := bucket + "/" + name
+	value, ok := presetCache.Get(key)
+	if ok {
+		if tasks, ok := value.([]string); ok {
+			return tasks, nil
+		}
+	}
+	result, err := helper.EntGetClient().Preset.Query().
+		Where(entPreset.Bucket(bucket)).
+		Where(entPreset.Name(name)).
+		First(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return nil, hes.New("preset(" + key + ") is not found")
+		}
+		return nil, err
+	}
+	tasks := NormalizeTasks(strings.Split(result.Tasks, "|"))
+	err = ValidatePresetTasks(bucket, tasks)
+	if err != nil {
+		return nil, err
+	}
+	presetCache.Add(key, tasks)
+	return tasks, nil
+}
+
+// RemovePresetCache 删除预设的缓存(仅当前实例)
+func RemovePresetCache(bucket, name string) {
+	presetCache.Remove(bucket + "/" + name)
+}
+
+// ExpandPresets 将preset/name展开为预设的任务列表，
+// 预设属于该任务之前最近的bucket
+func ExpandPresets(ctx context.Context, tasks []string) ([]string, error) {
+	result := make([]string, 0, len(tasks))
+	bucket := ""
+	for _, task := range tasks {
+		arr := strings.Split(task, "/")
+		switch arr[0] {
+		case "bucket":
+			if len(arr) >= 2 {
+				bucket = arr[1]
+			}
+		case taskPreset:
+			if len(arr) != 2 || arr[1] == "" {
+				return nil, hes.New("preset params is invalid")
+			}
+			if bucket == "" {
+				return nil, hes.New("preset should be used after bucket")
+			}
+			presetTasks, err := getPresetTasks(ctx, bucket, arr[1])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, presetTasks...)
+			continue
+		}
+		result = append(result, task)
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+func TestValidatePresetTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	storage.RegisterFinder("preset-test", func(_ context.Context, _ ...string) (*storage.Image, error) {
+		return nil, nil
+	})
+	t.Cleanup(func() {
+		storage.UnregisterFinder("preset-test")
+	})
+
+	assert.Nil(ValidatePresetTasks("test", []string{"fitResize/64/64", "autoOptim/70"}))
+	assert.Nil(ValidatePresetTasks("test", []string{"watermark/test%3Alogo"}))
+	assert.NotNil(ValidatePresetTasks("test", nil))
+	assert.NotNil(ValidatePresetTasks("test", []string{"preset/avatar"}))
+	assert.NotNil(ValidatePresetTasks("test", []string{"fitResize/64/64", "sign/abc"}))
+	assert.NotNil(ValidatePresetTasks("test", []string{"fitResize/64"}))
+
+	// 不可获取其它的图片
+	for _, tasks := range [][]string{
+		{"fitResize/64/64", "bucket/other/abc"},
+		{"proxy/https%3A%2F%2Fexample.com%2Fa.png"},
+		{"preset-test/abc"},
+		{"watermark/other%3Alogo"},
+		{"watermark/https%3A%2F%2Fexample.com%2Flogo.png"},
+	} {
+		err := ValidatePresetTasks("test", tasks)
+		assert.NotNil(err, tasks)
+	}
+}
+
+func TestExpandPresets(t *testing.T) {
+	assert := assert.New(t)
+
+	presetCache.Add("test/avatar-small", []string{"fitResize/64/64", "autoOptim/70"})
+	defer RemovePresetCache("test", "avatar-small")
+
+	tasks, err := ExpandPresets(context.Background(), []string{
+		"bucket/test/abc",
+		"preset/avatar-small",
+		"grayscale",
+	})
+	assert.Nil(err)
+	assert.Equal([]string{
+		"bucket/test/abc",
+		"fitResize/64/64",
+		"autoOptim/70",
+		"grayscale",
+	}, tasks)
+
+	// 无bucket时无法确认预设
+	_, err = ExpandPresets(context.Background(), []string{"preset/avatar-small"})
+	assert.NotNil(err)
+
+	_, err = ExpandPresets(context.Background(), []string{"bucket/test/abc", "preset"})
+	assert.NotNil(err)
+}
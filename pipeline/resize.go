@@ -22,25 +22,42 @@ import (
 	"github.com/vicanso/tiny-site/storage"
 )
 
+// GravitySmart 根据图片内容选择裁剪区域
+const GravitySmart = "smart"
+
 type resizeHandler func(image.Image, int, int, imaging.ResampleFilter) *image.NRGBA
 
 func resize(fn resizeHandler, img *storage.Image, width, height int) (*storage.Image, error) {
 	if img.Width <= width && img.Height <= height {
 		return img, nil
 	}
-	srcImage, err := decodeImage(img)
-	if err != nil {
-		return nil, err
-	}
-	srcImage = fn(srcImage, width, height, imaging.Lanczos)
-	data, err := encodeImage(srcImage, img.Type)
-	if err != nil {
-		return nil, err
+	return transform(img, func(srcImage image.Image) (image.Image, error) {
+		return fn(srcImage, width, height, imaging.Lanczos), nil
+	})
+}
+
+// getAnchor 将位置转换为对应的anchor，默认为居中
+func getAnchor(gravity string) imaging.Anchor {
+	switch gravity {
+	case PositionTopLeft:
+		return imaging.TopLeft
+	case PositionTop:
+		return imaging.Top
+	case PositionTopRight:
+		return imaging.TopRight
+	case PositionLeft:
+		return imaging.Left
+	case PositionRight:
+		return imaging.Right
+	case PositionBottomLeft:
+		return imaging.BottomLeft
+	case PositionBottom:
+		return imaging.Bottom
+	case PositionBottomRight:
+		return imaging.BottomRight
+	default:
+		return imaging.Center
 	}
-	img.Width = srcImage.Bounds().Dx()
-	img.Height = srcImage.Bounds().Dy()
-	img.SetData(data)
-	return img, nil
 }
 
 func NewFitResizeImage(width, height int) ImageJob {
@@ -49,10 +66,15 @@ func NewFitResizeImage(width, height int) ImageJob {
 	}
 }
 
-func NewFillResizeImage(width, height int) ImageJob {
+// NewFillResizeImage 填充式缩放，gravity为裁剪保留的位置，smart则根据图片内容选择
+func NewFillResizeImage(width, height int, gravity string) ImageJob {
 	return func(_ context.Context, img *storage.Image) (*storage.Image, error) {
+		if gravity == GravitySmart {
+			return resize(newSmartFill(), img, width, height)
+		}
+		anchor := getAnchor(gravity)
 		return resize(func(i1 image.Image, i2, i3 int, rf imaging.ResampleFilter) *image.NRGBA {
-			return imaging.Fill(i1, i2, i3, imaging.Center, rf)
+			return imaging.Fill(i1, i2, i3, anchor, rf)
 		}, img, width, height)
 	}
 }
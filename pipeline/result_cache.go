@@ -0,0 +1,264 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vicanso/hes"
+	lruttl "github.com/vicanso/lru-ttl"
+	"github.com/vicanso/tiny-site/cache"
+	"github.com/vicanso/tiny-site/config"
+	"github.com/vicanso/tiny-site/helper"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+var pipelineCacheConfig = config.MustGetPipelineCacheConfig()
+
+// 处理结果的缓存(lru+redis)，未配置有效期则为nil
+var resultCache = newResultCache()
+
+// 处理结果对应的图片(bucket/name)的索引前缀，用于按图片清除缓存
+var resultCacheIndexPrefix = config.MustGetRedisConfig().Prefix + "pipeline-index:"
+
+func init() {
+	// 本地文件有更新时清除引用该文件的缓存
+	storage.OnFileChange(func(ctx context.Context, storageName, file string) error {
+		_, err := purgeResultCacheSource(ctx, storage.FileCacheSource(storageName, file))
+		return err
+	})
+}
+
+func newResultCache() *lruttl.L2Cache {
+	if pipelineCacheConfig.TTL < time.Second {
+		return nil
+	}
+	return cache.NewMultilevelCache(
+		pipelineCacheConfig.LRUSize,
+		pipelineCacheConfig.TTL,
+		"pipeline:",
+	)
+}
+
+// NormalizeTasks 规范化任务列表，去除空白与多余的/
+func NormalizeTasks(tasks []string) []string {
+	result := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		task = strings.Trim(strings.TrimSpace(task), "/")
+		if task == "" {
+			continue
+		}
+		result = append(result, task)
+	}
+	return result
+}
+
+// IsAcceptNegotiated 处理结果是否根据Accept选择格式
+func IsAcceptNegotiated(tasks []string) bool {
+	for _, task := range tasks {
+		if strings.SplitN(task, "/", 2)[0] == "autoOptim" {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiateFormat 根据Accept选择输出的图片格式，返回空字符串表示保持原有格式
+func NegotiateFormat(header http.Header) string {
+	accept := header.Get("Accept")
+	if strings.Contains(accept, "image/avif") {
+		return ImageTypeAVIF
+	}
+	if strings.Contains(accept, "image/webp") {
+		return ImageTypeWEBP
+	}
+	return ""
+}
+
+// GetResultCacheKey 根据规范化的任务列表生成缓存的key，
+// 如果有根据Accept选择格式的任务，则key中包括选择的格式
+func GetResultCacheKey(tasks []string, header http.Header) string {
+	format := ""
+	if IsAcceptNegotiated(tasks) {
+		format = NegotiateFormat(header)
+	}
+	hash := sha256.Sum256([]byte(strings.Join(tasks, "|") + "|" + format))
+	return hex.EncodeToString(hash[:])
+}
+
+// imageSourceName 去除图片名称中指定的版本，
+// 替换或回滚时按图片名称清除所有版本的缓存
+func imageSourceName(name string) string {
+	result, _, err := storage.ParseImageVersion(name)
+	if err != nil {
+		return name
+	}
+	return result
+}
+
+// getResultCacheSources 获取任务列表中引用的图片(bucket/name)
+func getResultCacheSources(tasks []string) []string {
+	sources := make([]string, 0)
+	for _, task := range tasks {
+		arr := strings.Split(task, "/")
+		switch arr[0] {
+		case "bucket":
+			if len(arr) >= 3 {
+				sources = append(sources, arr[1]+"/"+imageSourceName(arr[2]))
+			}
+		case "watermark":
+			if len(arr) < 2 {
+				continue
+			}
+			source, _ := url.QueryUnescape(arr[1])
+			values := strings.SplitN(source, ":", 2)
+			if len(values) == 2 &&
+				!strings.HasPrefix(source, "http://") &&
+				!strings.HasPrefix(source, "https://") {
+				sources = append(sources, values[0]+"/"+imageSourceName(values[1]))
+			}
+		default:
+			// 本地文件的参数为相对路径
+			if storage.IsFileStorage(arr[0]) {
+				file, err := url.QueryUnescape(strings.Join(arr[1:], "/"))
+				if err == nil && file != "" {
+					sources = append(sources, storage.FileCacheSource(arr[0], file))
+				}
+				continue
+			}
+			// 从其它storage中加载的图片
+			_, err := storage.GetFinder(arr[0])
+			if err == nil && len(arr) >= 3 {
+				sources = append(sources, arr[1]+"/"+arr[2])
+			}
+		}
+	}
+	return sources
+}
+
+// marshalResult 将处理结果转换为"类型:原始长度\n数据"并使用snappy压缩
+func marshalResult(img *storage.Image) []byte {
+	buffer := bytes.Buffer{}
+	buffer.WriteString(img.Type)
+	buffer.WriteByte(':')
+	buffer.WriteString(strconv.Itoa(img.OriginalSize))
+	buffer.WriteByte('\n')
+	buffer.Write(img.Data)
+	return helper.SnappyEncode(buffer.Bytes())
+}
+
+func unmarshalResult(buf []byte) (*storage.Image, error) {
+	data, err := helper.SnappyDecode(buf)
+	if err != nil {
+		return nil, err
+	}
+	index := bytes.IndexByte(data, '\n')
+	if index < 0 {
+		return nil, hes.New("pipeline cache is invalid")
+	}
+	arr := strings.SplitN(string(data[:index]), ":", 2)
+	if len(arr) != 2 {
+		return nil, hes.New("pipeline cache is invalid")
+	}
+	originalSize, err := strconv.Atoi(arr[1])
+	if err != nil {
+		return nil, err
+	}
+	img := &storage.Image{
+		Type:         arr[0],
+		OriginalSize: originalSize,
+	}
+	img.SetData(data[index+1:])
+	return img, nil
+}
+
+// GetResultCache 获取缓存的处理结果，无缓存时返回nil
+func GetResultCache(ctx context.Context, key string) (*storage.Image, error) {
+	if resultCache == nil {
+		return nil, nil
+	}
+	buf, err := resultCache.GetBytes(ctx, key)
+	if err != nil {
+		if err == lruttl.ErrIsNil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return unmarshalResult(buf)
+}
+
+// SetResultCache 缓存处理结果，超过最大长度的不缓存。
+// 同时记录引用图片与缓存key的关系，用于按图片清除缓存
+func SetResultCache(ctx context.Context, key string, tasks []string, img *storage.Image) error {
+	if resultCache == nil || img.Size > pipelineCacheConfig.MaxSize {
+		return nil
+	}
+	ttl := pipelineCacheConfig.TTL
+	err := resultCache.SetBytes(ctx, key, marshalResult(img), ttl)
+	if err != nil {
+		return err
+	}
+	sources := getResultCacheSources(tasks)
+	if len(sources) == 0 {
+		return nil
+	}
+	pipe := helper.RedisGetClient().TxPipeline()
+	for _, source := range sources {
+		indexKey := resultCacheIndexPrefix + source
+		pipe.SAdd(ctx, indexKey, key)
+		pipe.Expire(ctx, indexKey, ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// PurgeResultCache 清除引用该图片的所有处理结果缓存以及水印的缓存，返回清除的数量。
+// 其它实例的内存缓存无法清除，在有效期后失效
+func PurgeResultCache(ctx context.Context, bucket, name string) (int, error) {
+	purgeWatermarkCache(bucket, name)
+	return purgeResultCacheSource(ctx, bucket+"/"+name)
+}
+
+// purgeResultCacheSource 清除引用该来源的所有处理结果缓存
+func purgeResultCacheSource(ctx context.Context, source string) (int, error) {
+	if resultCache == nil {
+		return 0, nil
+	}
+	indexKey := resultCacheIndexPrefix + source
+	client := helper.RedisGetClient()
+	keys, err := client.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		_, err = resultCache.Del(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = client.Del(ctx, indexKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
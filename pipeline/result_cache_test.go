@@ -0,0 +1,104 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+func TestNormalizeTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{
+		"bucket/test/abc",
+		"fitResize/100/100",
+	}, NormalizeTasks([]string{
+		" bucket/test/abc/",
+		"",
+		"fitResize/100/100",
+		"/",
+	}))
+}
+
+func TestGetResultCacheKey(t *testing.T) {
+	assert := assert.New(t)
+
+	webpHeader := http.Header{}
+	webpHeader.Set("Accept", "image/webp,*/*")
+	avifHeader := http.Header{}
+	avifHeader.Set("Accept", "image/avif,image/webp,*/*")
+
+	assert.Equal(ImageTypeWEBP, NegotiateFormat(webpHeader))
+	assert.Equal(ImageTypeAVIF, NegotiateFormat(avifHeader))
+	assert.Equal("", NegotiateFormat(http.Header{}))
+
+	// 无自动选择格式的任务，与Accept无关
+	tasks := []string{"bucket/test/abc", "optim/80"}
+	assert.False(IsAcceptNegotiated(tasks))
+	assert.Equal(GetResultCacheKey(tasks, webpHeader), GetResultCacheKey(tasks, avifHeader))
+
+	tasks = []string{"bucket/test/abc", "autoOptim/80"}
+	assert.True(IsAcceptNegotiated(tasks))
+	assert.NotEqual(GetResultCacheKey(tasks, webpHeader), GetResultCacheKey(tasks, avifHeader))
+	assert.Equal(GetResultCacheKey(tasks, webpHeader), GetResultCacheKey(tasks, webpHeader))
+	assert.Equal(64, len(GetResultCacheKey(tasks, webpHeader)))
+}
+
+func TestGetResultCacheSources(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{
+		"test/abc",
+		"logo/icon",
+	}, getResultCacheSources([]string{
+		"bucket/test/abc",
+		"fitResize/100/100",
+		"watermark/logo%3Aicon/center",
+		"watermark/https%3A%2F%2Fexample.com%2Flogo.png",
+	}))
+
+	// 指定版本的按图片名称清除
+	assert.Equal([]string{
+		"test/abc",
+		"logo/icon",
+	}, getResultCacheSources([]string{
+		"bucket/test/abc@v3",
+		"watermark/logo%3Aicon@v2/center",
+	}))
+}
+
+func TestMarshalResult(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &storage.Image{
+		Type:         ImageTypeWEBP,
+		OriginalSize: 1024,
+	}
+	img.SetData([]byte("abc\n123"))
+
+	result, err := unmarshalResult(marshalResult(img))
+	assert.Nil(err)
+	assert.Equal(img.Type, result.Type)
+	assert.Equal(img.OriginalSize, result.OriginalSize)
+	assert.Equal(img.Data, result.Data)
+	assert.Equal(img.Size, result.Size)
+
+	_, err = unmarshalResult([]byte("abc"))
+	assert.NotNil(err)
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+// 保存任务的名称，格式为save/storage/bucket/key，只能为最后一个任务
+const taskSave = "save"
+
+// HasSaveTask 判断是否有保存的任务，有保存任务的不能使用缓存
+func HasSaveTask(tasks []string) bool {
+	for _, task := range tasks {
+		if strings.SplitN(task, "/", 2)[0] == taskSave {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSaveImage 将处理后的图片保存至storage，图片不做调整
+func NewSaveImage(writer storage.ImageWriter, bucket, key string) ImageJob {
+	return func(ctx context.Context, img *storage.Image) (*storage.Image, error) {
+		if img == nil {
+			return nil, hes.New("image of save can not be nil")
+		}
+		err := encodeImageIfChanged(img)
+		if err != nil {
+			return nil, err
+		}
+		err = stripImageMetadata(img)
+		if err != nil {
+			return nil, err
+		}
+		err = writer(ctx, bucket, key, img.Data)
+		if err != nil {
+			return nil, err
+		}
+		return img, nil
+	}
+}
+
+func parseSave(params []string, _ http.Header) (ImageJob, error) {
+	if len(params) < 4 || params[1] == "" || params[2] == "" {
+		return nil, hes.New("save params is invalid")
+	}
+	writer, err := storage.GetWriter(params[1])
+	if err != nil {
+		return nil, err
+	}
+	// key可包含/
+	key, err := url.QueryUnescape(strings.Join(params[3:], "/"))
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, hes.New("key of save can not be empty")
+	}
+	return NewSaveImage(writer, params[2], key), nil
+}
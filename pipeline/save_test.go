@@ -0,0 +1,95 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+func TestSaveImage(t *testing.T) {
+	assert := assert.New(t)
+
+	var savedBucket, savedKey string
+	var savedData []byte
+	writer := func(_ context.Context, bucket, key string, data []byte) error {
+		savedBucket = bucket
+		savedKey = key
+		savedData = data
+		return nil
+	}
+	img, err := storage.NewImageFromBytes(newBenchmarkJPEG(400, 300))
+	assert.Nil(err)
+
+	result, err := Do(context.Background(), img,
+		NewFitResizeImage(200, 200),
+		NewSaveImage(writer, "test", "a/b.jpg"),
+	)
+	assert.Nil(err)
+	assert.Equal("test", savedBucket)
+	assert.Equal("a/b.jpg", savedKey)
+	// 保存的为调整后的数据
+	assert.Equal(result.Data, savedData)
+	assert.Equal(200, result.Width)
+}
+
+// testSaveBlob 用于测试的blob，不保存数据
+type testSaveBlob struct{}
+
+func (testSaveBlob) Get(_ context.Context, _, _ string) ([]byte, error) {
+	return nil, nil
+}
+
+func (testSaveBlob) Put(_ context.Context, _, _ string, _ []byte) error {
+	return nil
+}
+
+func (testSaveBlob) Delete(_ context.Context, _, _ string) error {
+	return nil
+}
+
+func TestParseSave(t *testing.T) {
+	assert := assert.New(t)
+
+	storage.RegisterBlob("save-test", testSaveBlob{})
+	t.Cleanup(func() {
+		storage.UnregisterBlob("save-test")
+	})
+
+	_, err := Parse([]string{"bucket/test/a", "save/save-test/test/a%2Fb.jpg"}, http.Header{})
+	assert.Nil(err)
+
+	_, err = Parse([]string{"bucket/test/a", "save/save-test/test/a.jpg", "grayscale"}, http.Header{})
+	assert.Equal("save should be the last task", hes.Wrap(err).Message)
+
+	_, err = Parse([]string{"bucket/test/a", "save/save-test/test"}, http.Header{})
+	assert.NotNil(err)
+
+	_, err = Parse([]string{"bucket/test/a", "save/not-exists/test/a.jpg"}, http.Header{})
+	assert.NotNil(err)
+
+	assert.True(HasSaveTask([]string{"bucket/test/a", "save/save-test/test/a.jpg"}))
+	assert.False(HasSaveTask([]string{"bucket/test/a", "grayscale"}))
+
+	// 保存的任务需要签名
+	_, ok := GetTaskBuckets([]string{"bucket/test/a", "save/save-test/test/a.jpg"})
+	assert.False(ok)
+	assert.NotNil(ValidatePresetTasks("test", []string{"save/save-test/test/a.jpg"}))
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+// 签名任务的名称，格式为sign/签名/过期时间(unix秒，可选)
+const taskSign = "sign"
+
+var (
+	ErrSignatureRequired = hes.NewWithStatusCode("pipeline should be signed", http.StatusForbidden)
+	ErrSignatureInvalid  = hes.NewWithStatusCode("signature of pipeline is invalid", http.StatusForbidden)
+	ErrSignatureExpired  = hes.NewWithStatusCode("signature of pipeline is expired", http.StatusForbidden)
+)
+
+// Signature 签名信息
+type Signature struct {
+	// 签名
+	Value string
+	// 过期时间(unix秒)，为0表示不过期
+	ExpiredAt int64
+}
+
+// SplitSignature 从任务列表中分离签名任务，无签名时返回nil
+func SplitSignature(tasks []string) ([]string, *Signature, error) {
+	result := make([]string, 0, len(tasks))
+	var signature *Signature
+	for _, task := range tasks {
+		arr := strings.Split(task, "/")
+		if arr[0] != taskSign {
+			result = append(result, task)
+			continue
+		}
+		if len(arr) < 2 || arr[1] == "" || signature != nil {
+			return nil, nil, ErrSignatureInvalid
+		}
+		signature = &Signature{
+			Value: arr[1],
+		}
+		if len(arr) > 2 && arr[2] != "" {
+			expiredAt, err := strconv.ParseInt(arr[2], 10, 64)
+			if err != nil {
+				return nil, nil, ErrSignatureInvalid
+			}
+			signature.ExpiredAt = expiredAt
+		}
+	}
+	return result, signature, nil
+}
+
+// sign 对任务列表与过期时间签名
+func sign(tasks []string, key string, expiredAt int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.Join(tasks, "|")))
+	mac.Write([]byte("|"))
+	if expiredAt != 0 {
+		mac.Write([]byte(strconv.FormatInt(expiredAt, 10)))
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign 使用第一个key对任务列表签名，返回添加签名任务后的任务列表，
+// ttl为0表示不过期
+func Sign(tasks []string, keys []string, ttl time.Duration) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, hes.New("signed keys can not be empty")
+	}
+	tasks = NormalizeTasks(tasks)
+	tasks, _, err := SplitSignature(tasks)
+	if err != nil {
+		return nil, err
+	}
+	var expiredAt int64
+	if ttl > 0 {
+		expiredAt = time.Now().Add(ttl).Unix()
+	}
+	task := taskSign + "/" + sign(tasks, keys[0], expiredAt)
+	if expiredAt != 0 {
+		task += "/" + strconv.FormatInt(expiredAt, 10)
+	}
+	return append(tasks, task), nil
+}
+
+// Verify 校验签名，任一key签名一致则通过，以支持key的轮换
+func Verify(tasks []string, signature *Signature, keys []string) error {
+	if signature == nil {
+		return ErrSignatureRequired
+	}
+	if signature.ExpiredAt != 0 && time.Now().Unix() > signature.ExpiredAt {
+		return ErrSignatureExpired
+	}
+	for _, key := range keys {
+		if hmac.Equal([]byte(sign(tasks, key, signature.ExpiredAt)), []byte(signature.Value)) {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+// GetTaskBuckets 获取任务列表中引用的bucket，
+// 如果引用了外部的图片(proxy、其它storage或http的水印)或保存至storage则返回false，此类任务必须签名，
+// 引用的bucket仍全部返回，用于签名时校验权限。
+// 本地文件的storage与bucket一样按权限校验，由GetTaskFileStorages获取
+func GetTaskBuckets(tasks []string) ([]string, bool) {
+	buckets := make([]string, 0)
+	external := false
+	for _, task := range tasks {
+		arr := strings.Split(task, "/")
+		switch arr[0] {
+		case "bucket":
+			if len(arr) >= 2 {
+				buckets = append(buckets, arr[1])
+			}
+		case "proxy", taskSave:
+			external = true
+		case "watermark":
+			if len(arr) < 2 {
+				continue
+			}
+			source, _ := url.QueryUnescape(arr[1])
+			if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+				external = true
+				continue
+			}
+			buckets = append(buckets, strings.SplitN(source, ":", 2)[0])
+		default:
+			if storage.IsFileStorage(arr[0]) {
+				continue
+			}
+			// 从其它storage中加载的图片
+			if _, err := storage.GetFinder(arr[0]); err == nil {
+				external = true
+			}
+		}
+	}
+	return buckets, !external
+}
+
+// GetTaskFileStorages 获取任务列表中引用的本地文件storage
+func GetTaskFileStorages(tasks []string) []string {
+	storages := make([]string, 0)
+	for _, task := range tasks {
+		name := strings.SplitN(task, "/", 2)[0]
+		if storage.IsFileStorage(name) {
+			storages = append(storages, name)
+		}
+	}
+	return storages
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/ent"
+	entStorage "github.com/vicanso/tiny-site/ent/storage"
+	"github.com/vicanso/tiny-site/schema"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := []string{"new", "old"}
+	signedTasks, err := Sign([]string{"bucket/test/abc", "fitResize/100/100"}, keys, 0)
+	assert.Nil(err)
+	assert.Equal(3, len(signedTasks))
+
+	tasks, signature, err := SplitSignature(signedTasks)
+	assert.Nil(err)
+	assert.Equal([]string{"bucket/test/abc", "fitResize/100/100"}, tasks)
+	assert.Equal(int64(0), signature.ExpiredAt)
+	assert.Nil(Verify(tasks, signature, keys))
+
+	// key轮换后旧的签名仍可用
+	assert.Nil(Verify(tasks, signature, []string{"newest", "new"}))
+	assert.Equal(ErrSignatureInvalid, Verify(tasks, signature, []string{"other"}))
+
+	// 任务调整后签名无效
+	assert.Equal(ErrSignatureInvalid, Verify([]string{"bucket/test/abc", "fitResize/1000/1000"}, signature, keys))
+
+	assert.Equal(ErrSignatureRequired, Verify(tasks, nil, keys))
+
+	// 有效期
+	signedTasks, err = Sign(tasks, keys, time.Minute)
+	assert.Nil(err)
+	_, signature, err = SplitSignature(signedTasks)
+	assert.Nil(err)
+	assert.NotEqual(int64(0), signature.ExpiredAt)
+	assert.Nil(Verify(tasks, signature, keys))
+
+	expiredAt := time.Now().Add(-time.Minute).Unix()
+	signature = &Signature{
+		Value:     sign(tasks, keys[0], expiredAt),
+		ExpiredAt: expiredAt,
+	}
+	assert.Equal(ErrSignatureExpired, Verify(tasks, signature, keys))
+
+	// 修改过期时间则签名无效
+	signature.ExpiredAt = time.Now().Add(time.Hour).Unix()
+	assert.Equal(ErrSignatureInvalid, Verify(tasks, signature, keys))
+
+	_, err = Sign(tasks, nil, 0)
+	assert.NotNil(err)
+}
+
+func TestSplitSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, err := SplitSignature([]string{"bucket/test/abc", "sign"})
+	assert.Equal(ErrSignatureInvalid, err)
+	_, _, err = SplitSignature([]string{"sign/a", "sign/b"})
+	assert.Equal(ErrSignatureInvalid, err)
+	_, _, err = SplitSignature([]string{"sign/a/b"})
+	assert.Equal(ErrSignatureInvalid, err)
+
+	tasks, signature, err := SplitSignature([]string{"bucket/test/abc"})
+	assert.Nil(err)
+	assert.Nil(signature)
+	assert.Equal([]string{"bucket/test/abc"}, tasks)
+
+	_, signature, err = SplitSignature([]string{"bucket/test/abc", "sign/a/" + strconv.Itoa(100)})
+	assert.Nil(err)
+	assert.Equal("a", signature.Value)
+	assert.Equal(int64(100), signature.ExpiredAt)
+}
+
+func TestGetTaskBuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	storage.RegisterFinder("sign-test", func(_ context.Context, _ ...string) (*storage.Image, error) {
+		return nil, nil
+	})
+	t.Cleanup(func() {
+		storage.UnregisterFinder("sign-test")
+	})
+
+	buckets, ok := GetTaskBuckets([]string{
+		"bucket/test/abc",
+		"fitResize/100/100",
+		"watermark/logo%3Aicon",
+	})
+	assert.True(ok)
+	assert.Equal([]string{"test", "logo"}, buckets)
+
+	for _, tasks := range [][]string{
+		{"proxy/https%3A%2F%2Fexample.com%2F1.png"},
+		{"bucket/test/abc", "watermark/https%3A%2F%2Fexample.com%2Flogo.png"},
+		{"sign-test/test/abc"},
+	} {
+		_, ok = GetTaskBuckets(tasks)
+		assert.False(ok)
+	}
+
+	// 引用了外部图片时仍返回引用的bucket
+	buckets, ok = GetTaskBuckets([]string{
+		"bucket/test/abc",
+		"watermark/https%3A%2F%2Fexample.com%2Flogo.png",
+	})
+	assert.False(ok)
+	assert.Equal([]string{"test"}, buckets)
+}
+
+func TestGetTaskFileStorages(t *testing.T) {
+	assert := assert.New(t)
+
+	name := "sign-file-test"
+	err := storage.ReloadStorage(&ent.Storage{
+		Name:     name,
+		Category: entStorage.Category(schema.StorageCategoryFile),
+		URI:      "file://" + t.TempDir(),
+		Status:   schema.StatusEnabled,
+	})
+	assert.Nil(err)
+	t.Cleanup(func() {
+		storage.RemoveStorage(name)
+	})
+
+	tasks := []string{
+		"bucket/test/abc",
+		name + "/a%2Fb.png",
+		"fitResize/100/100",
+	}
+	// 本地文件按storage校验权限，不作为外部的图片
+	buckets, ok := GetTaskBuckets(tasks)
+	assert.True(ok)
+	assert.Equal([]string{"test"}, buckets)
+	assert.Equal([]string{name}, GetTaskFileStorages(tasks))
+
+	assert.Empty(GetTaskFileStorages([]string{"bucket/test/abc"}))
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// 计算边缘时缩小后的最大边长，减少计算量
+const smartCropAnalyzeSize = 256
+
+// edgeEnergy 计算图片每列与每行的边缘强度总和
+func edgeEnergy(img image.Image) ([]float64, []float64) {
+	gray := imaging.Grayscale(img)
+	width := gray.Bounds().Dx()
+	height := gray.Bounds().Dy()
+	cols := make([]float64, width)
+	rows := make([]float64, height)
+	// 灰度图的rgb均相同，取r即可
+	value := func(x, y int) float64 {
+		return float64(gray.Pix[y*gray.Stride+x*4])
+	}
+	abs := func(v float64) float64 {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			dx := value(x+1, y) - value(x-1, y)
+			dy := value(x, y+1) - value(x, y-1)
+			energy := abs(dx) + abs(dy)
+			cols[x] += energy
+			rows[y] += energy
+		}
+	}
+	return cols, rows
+}
+
+// bestWindow 选择边缘强度总和最大的区间，相同时选择更接近中间的
+func bestWindow(energies []float64, size int) int {
+	count := len(energies)
+	if size >= count {
+		return 0
+	}
+	sum := 0.0
+	for i := 0; i < size; i++ {
+		sum += energies[i]
+	}
+	center := (count - size) / 2
+	distance := func(v int) int {
+		if v > center {
+			return v - center
+		}
+		return center - v
+	}
+	best := 0
+	bestSum := sum
+	for start := 1; start+size <= count; start++ {
+		sum += energies[start+size-1] - energies[start-1]
+		if sum > bestSum ||
+			(sum == bestSum && distance(start) < distance(best)) {
+			best = start
+			bestSum = sum
+		}
+	}
+	return best
+}
+
+// smartCropRect 按宽高比选择边缘最丰富的区域
+func smartCropRect(img image.Image, width, height int) image.Rectangle {
+	bounds := img.Bounds()
+	srcWidth := bounds.Dx()
+	srcHeight := bounds.Dy()
+	// 裁剪区域的宽高（与目标宽高比一致）
+	cropWidth := srcWidth
+	cropHeight := srcWidth * height / width
+	if cropHeight > srcHeight {
+		cropHeight = srcHeight
+		cropWidth = srcHeight * width / height
+	}
+
+	analyzeImage := img
+	scale := 1.0
+	if srcWidth > smartCropAnalyzeSize || srcHeight > smartCropAnalyzeSize {
+		analyzeImage = imaging.Fit(img, smartCropAnalyzeSize, smartCropAnalyzeSize, imaging.Box)
+		scale = float64(srcWidth) / float64(analyzeImage.Bounds().Dx())
+	}
+	cols, rows := edgeEnergy(analyzeImage)
+
+	x := 0
+	y := 0
+	if cropWidth < srcWidth {
+		x = int(float64(bestWindow(cols, int(float64(cropWidth)/scale))) * scale)
+		if x+cropWidth > srcWidth {
+			x = srcWidth - cropWidth
+		}
+	}
+	if cropHeight < srcHeight {
+		y = int(float64(bestWindow(rows, int(float64(cropHeight)/scale))) * scale)
+		if y+cropHeight > srcHeight {
+			y = srcHeight - cropHeight
+		}
+	}
+	return image.Rect(x, y, x+cropWidth, y+cropHeight).Add(bounds.Min)
+}
+
+// newSmartFill 根据边缘强度裁剪后再缩放，
+// 裁剪区域只计算一次，动图的所有帧使用第一帧的裁剪区域，避免画面抖动
+func newSmartFill() resizeHandler {
+	var rect *image.Rectangle
+	return func(img image.Image, width, height int, filter imaging.ResampleFilter) *image.NRGBA {
+		if width <= 0 || height <= 0 {
+			return &image.NRGBA{}
+		}
+		if rect == nil {
+			r := smartCropRect(img, width, height)
+			rect = &r
+		}
+		return imaging.Resize(imaging.Crop(img, *rect), width, height, filter)
+	}
+}
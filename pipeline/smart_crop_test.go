@@ -0,0 +1,63 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBestWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(3, bestWindow([]float64{0, 0, 0, 5, 5, 0, 0}, 2))
+	// 相同时选择居中的区间
+	assert.Equal(2, bestWindow([]float64{1, 1, 1, 1, 1, 1}, 2))
+	assert.Equal(0, bestWindow([]float64{1, 1}, 3))
+}
+
+func TestSmartCropRect(t *testing.T) {
+	assert := assert.New(t)
+
+	// 右侧有白色方块，裁剪区域应包含该方块
+	img := imaging.New(600, 200, color.Black)
+	img = imaging.Paste(img, imaging.New(100, 100, color.White), image.Pt(450, 50))
+	rect := smartCropRect(img, 100, 100)
+	assert.Equal(200, rect.Dx())
+	assert.Equal(200, rect.Dy())
+	assert.True(rect.Min.X <= 450)
+	assert.True(rect.Max.X >= 550)
+
+	// 下方有白色方块
+	img = imaging.New(200, 600, color.Black)
+	img = imaging.Paste(img, imaging.New(100, 100, color.White), image.Pt(50, 480))
+	rect = smartCropRect(img, 200, 100)
+	assert.Equal(200, rect.Dx())
+	assert.Equal(100, rect.Dy())
+	assert.True(rect.Min.Y <= 480)
+	assert.True(rect.Max.Y >= 580)
+}
+
+func TestGetAnchor(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(imaging.TopLeft, getAnchor(PositionTopLeft))
+	assert.Equal(imaging.BottomRight, getAnchor(PositionBottomRight))
+	assert.Equal(imaging.Center, getAnchor(""))
+}
@@ -17,17 +17,18 @@ package pipeline
 import (
 	"context"
 
-	entImage "github.com/vicanso/tiny-site/ent/image"
-	"github.com/vicanso/tiny-site/helper"
 	"github.com/vicanso/tiny-site/storage"
 )
 
 func NewGetEntImage(bucket, name string) ImageJob {
 	return func(ctx context.Context, _ *storage.Image) (*storage.Image, error) {
-		img, err := helper.EntGetClient().Image.Query().
-			Where(entImage.Bucket(bucket)).
-			Where(entImage.Name(name)).
-			First(ctx)
+		// 图片数据可能保存在storage中，由ent storage统一获取
+		img, err := storage.Ent().Get(ctx, bucket, name)
+		if err != nil {
+			return nil, err
+		}
+		// 输出时按bucket的策略移除元数据
+		policy, err := storage.GetBucketExifPolicy(ctx, bucket)
 		if err != nil {
 			return nil, err
 		}
@@ -38,6 +39,7 @@ func NewGetEntImage(bucket, name string) ImageJob {
 			Width:        img.Width,
 			Height:       img.Height,
 			Data:         img.Data,
+			ExifPolicy:   policy,
 		}, nil
 	}
 }
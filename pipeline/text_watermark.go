@@ -0,0 +1,174 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/storage"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const (
+	// 文字水印不重复
+	TextRepeatNone = ""
+	// 文字水印平铺
+	TextRepeatTiled = "tiled"
+	// 文字水印倾斜平铺
+	TextRepeatDiagonal = "diagonal"
+)
+
+const (
+	// 字体大小的范围
+	TextWatermarkMinFontSize = 8
+	TextWatermarkMaxFontSize = 512
+	// 平铺时最多绘制的文字数量，避免过多的文字耗尽CPU
+	textWatermarkMaxTiles = 2000
+)
+
+var textWatermarkFont = mustParseTextWatermarkFont()
+
+func mustParseTextWatermarkFont() *truetype.Font {
+	font, err := truetype.Parse(goregular.TTF)
+	if err != nil {
+		panic(err)
+	}
+	return font
+}
+
+// parseHexColor 转换颜色，格式为RRGGBB或RRGGBBAA
+func parseHexColor(s string) (color.NRGBA, error) {
+	c := color.NRGBA{
+		A: 0xff,
+	}
+	buf, err := hex.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || (len(buf) != 3 && len(buf) != 4) {
+		return c, hes.New("color should be RRGGBB or RRGGBBAA")
+	}
+	c.R = buf[0]
+	c.G = buf[1]
+	c.B = buf[2]
+	if len(buf) == 4 {
+		c.A = buf[3]
+	}
+	return c, nil
+}
+
+// getTextWatermarkSteps 获取平铺时横向与纵向的步长，步长至少为1px，
+// 绘制的数量超过限制时等比增大步长
+func getTextWatermarkSteps(areaWidth, areaHeight, textWidth, textHeight, gap float64) (float64, float64) {
+	stepX := math.Max(textWidth+gap, 1)
+	stepY := math.Max(textHeight+gap, 1)
+	// 奇数行错开半个文字宽度，因此每行多一个
+	tiles := (math.Ceil(areaWidth/stepX) + 1) * math.Ceil(areaHeight/stepY)
+	if tiles > textWatermarkMaxTiles {
+		scale := math.Sqrt(tiles / textWatermarkMaxTiles)
+		stepX *= scale
+		stepY *= scale
+	}
+	return stepX, stepY
+}
+
+// TextWatermarkOption 文字水印的配置
+type TextWatermarkOption struct {
+	// 水印文字
+	Text string
+	// 字体大小
+	FontSize float64
+	// 字体颜色
+	Color color.NRGBA
+	// 透明度(0-1)
+	Opacity float64
+	// 水印位置，平铺时无效
+	Position string
+	// 重复方式：不重复、平铺、倾斜平铺
+	Repeat string
+	// 与边缘的距离(平铺时为文字的间距)
+	Margin int
+}
+
+// NewTextWatermark 添加文字水印
+func NewTextWatermark(opt TextWatermarkOption) ImageJob {
+	return func(_ context.Context, img *storage.Image) (*storage.Image, error) {
+		return transform(img, func(srcImage image.Image) (image.Image, error) {
+			dc := gg.NewContextForImage(srcImage)
+			fontSize := math.Max(TextWatermarkMinFontSize, math.Min(TextWatermarkMaxFontSize, opt.FontSize))
+			dc.SetFontFace(truetype.NewFace(textWatermarkFont, &truetype.Options{
+				Size: fontSize,
+			}))
+			c := opt.Color
+			c.A = uint8(float64(c.A) * opt.Opacity)
+			dc.SetColor(c)
+
+			width := dc.Width()
+			height := dc.Height()
+			textWidth, textHeight := dc.MeasureString(opt.Text)
+			switch opt.Repeat {
+			case TextRepeatTiled, TextRepeatDiagonal:
+				gap := float64(opt.Margin)
+				// 默认间距为字体大小
+				if gap <= 0 {
+					gap = fontSize
+				}
+				startX := 0.0
+				startY := 0.0
+				endX := float64(width)
+				endY := float64(height)
+				if opt.Repeat == TextRepeatDiagonal {
+					// 旋转后需要覆盖整个图片，因此以对角线长度为绘制区域
+					diagonal := math.Hypot(float64(width), float64(height))
+					startX = (float64(width) - diagonal) / 2
+					startY = (float64(height) - diagonal) / 2
+					endX = startX + diagonal
+					endY = startY + diagonal
+					dc.RotateAbout(gg.Radians(-45), float64(width)/2, float64(height)/2)
+				}
+				stepX, stepY := getTextWatermarkSteps(endX-startX, endY-startY, textWidth, textHeight, gap)
+				row := 0
+				for y := startY; y < endY; y += stepY {
+					// 奇数行错开半个文字宽度
+					offset := 0.0
+					if row%2 == 1 {
+						offset = stepX / 2
+					}
+					for x := startX - offset; x < endX; x += stepX {
+						dc.DrawStringAnchored(opt.Text, x, y, 0, 1)
+					}
+					row++
+				}
+			default:
+				pt := getWatermarkPosition(
+					opt.Position,
+					width,
+					height,
+					int(math.Ceil(textWidth)),
+					int(math.Ceil(textHeight)),
+					opt.Margin,
+				)
+				dc.DrawStringAnchored(opt.Text, float64(pt.X), float64(pt.Y), 0, 1)
+			}
+
+			return dc.Image(), nil
+		})
+	}
+}
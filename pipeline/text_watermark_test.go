@@ -0,0 +1,113 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+func newTestImage(t *testing.T, width, height int) *storage.Image {
+	buffer := bytes.Buffer{}
+	err := png.Encode(&buffer, imaging.New(width, height, color.Black))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := storage.NewImageFromBytes(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestParseHexColor(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := parseHexColor("ff8000")
+	assert.Nil(err)
+	assert.Equal(color.NRGBA{R: 0xff, G: 0x80, A: 0xff}, c)
+
+	c, err = parseHexColor("#ff800080")
+	assert.Nil(err)
+	assert.Equal(color.NRGBA{R: 0xff, G: 0x80, A: 0x80}, c)
+
+	_, err = parseHexColor("ff80")
+	assert.NotNil(err)
+}
+
+func TestTextWatermark(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, repeat := range []string{
+		TextRepeatNone,
+		TextRepeatTiled,
+		TextRepeatDiagonal,
+	} {
+		img := newTestImage(t, 200, 100)
+		originalData := img.Data
+		result, err := Do(context.Background(), img, NewTextWatermark(TextWatermarkOption{
+			Text:     "confidential",
+			FontSize: 14,
+			Color:    color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+			Opacity:  0.5,
+			Position: PositionCenter,
+			Repeat:   repeat,
+		}))
+		assert.Nil(err)
+		assert.Equal(200, result.Width)
+		assert.Equal(100, result.Height)
+		assert.NotEqual(originalData, result.Data)
+	}
+}
+
+func TestParseTextWatermark(t *testing.T) {
+	assert := assert.New(t)
+
+	job, err := parseTextWatermark([]string{"textWatermark", "test", "16", "ffffff", "0.5", "", "tiled"}, nil)
+	assert.Nil(err)
+	assert.NotNil(job)
+
+	for _, fontSize := range []string{"0.01", "7", "513", "abc"} {
+		_, err = parseTextWatermark([]string{"textWatermark", "test", fontSize}, nil)
+		assert.Equal("font size of text watermark should be 8-512", hes.Wrap(err).Message)
+	}
+}
+
+func TestGetTextWatermarkSteps(t *testing.T) {
+	assert := assert.New(t)
+
+	stepX, stepY := getTextWatermarkSteps(200, 100, 40, 10, 10)
+	assert.Equal(50.0, stepX)
+	assert.Equal(20.0, stepY)
+
+	// 步长至少为1px
+	stepX, stepY = getTextWatermarkSteps(10, 10, 0, 0, 0)
+	assert.Equal(1.0, stepX)
+	assert.Equal(1.0, stepY)
+
+	// 超出数量限制时增大步长
+	stepX, stepY = getTextWatermarkSteps(10000, 10000, 10, 10, 1)
+	tiles := (math.Ceil(10000/stepX) + 1) * math.Ceil(10000/stepY)
+	assert.LessOrEqual(tiles, float64(textWatermarkMaxTiles)*1.1)
+}
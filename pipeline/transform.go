@@ -0,0 +1,104 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/storage"
+)
+
+const (
+	// 水平翻转
+	FlipHorizontal = "h"
+	// 垂直翻转
+	FlipVertical = "v"
+)
+
+type transformHandler func(image.Image) (image.Image, error)
+
+// transform 对图像转换，编码在所有任务完成后再执行。
+// 动图则对每一帧执行转换
+func transform(img *storage.Image, fn transformHandler) (*storage.Image, error) {
+	anim, err := img.Animation()
+	if err != nil {
+		return nil, err
+	}
+	if anim != nil {
+		frames := make([]image.Image, len(anim.Frames))
+		for index, frame := range anim.Frames {
+			frames[index], err = fn(frame)
+			if err != nil {
+				return nil, err
+			}
+		}
+		img.SetAnimation(anim.WithFrames(frames))
+		return img, nil
+	}
+	srcImage, err := decodeImage(img)
+	if err != nil {
+		return nil, err
+	}
+	srcImage, err = fn(srcImage)
+	if err != nil {
+		return nil, err
+	}
+	img.SetImage(srcImage)
+	return img, nil
+}
+
+// NewCropImage 按指定区域裁剪图片，超出图片的部分忽略
+func NewCropImage(x, y, width, height int) ImageJob {
+	return func(_ context.Context, img *storage.Image) (*storage.Image, error) {
+		return transform(img, func(srcImage image.Image) (image.Image, error) {
+			bounds := srcImage.Bounds()
+			rect := image.Rect(x, y, x+width, y+height).
+				Add(bounds.Min).
+				Intersect(bounds)
+			if rect.Empty() {
+				return nil, hes.New("crop area is out of image")
+			}
+			return imaging.Crop(srcImage, rect), nil
+		})
+	}
+}
+
+// NewRotateImage 按逆时针旋转图片，空白区域为透明
+func NewRotateImage(angle float64) ImageJob {
+	return func(_ context.Context, img *storage.Image) (*storage.Image, error) {
+		return transform(img, func(srcImage image.Image) (image.Image, error) {
+			return imaging.Rotate(srcImage, angle, color.Transparent), nil
+		})
+	}
+}
+
+// NewFlipImage 翻转图片，h为水平翻转，v为垂直翻转
+func NewFlipImage(direction string) ImageJob {
+	return func(_ context.Context, img *storage.Image) (*storage.Image, error) {
+		return transform(img, func(srcImage image.Image) (image.Image, error) {
+			switch direction {
+			case FlipHorizontal:
+				return imaging.FlipH(srcImage), nil
+			case FlipVertical:
+				return imaging.FlipV(srcImage), nil
+			}
+			return nil, hes.New("flip direction should be h or v")
+		})
+	}
+}
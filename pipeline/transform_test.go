@@ -0,0 +1,82 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCropImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewCropImage(10, 20, 50, 30)(context.Background(), newTestImage(t, 200, 100))
+	assert.Nil(err)
+	assert.Equal(50, img.Width)
+	assert.Equal(30, img.Height)
+
+	// 超出部分忽略
+	img, err = NewCropImage(180, 90, 50, 30)(context.Background(), newTestImage(t, 200, 100))
+	assert.Nil(err)
+	assert.Equal(20, img.Width)
+	assert.Equal(10, img.Height)
+
+	_, err = NewCropImage(300, 0, 50, 30)(context.Background(), newTestImage(t, 200, 100))
+	assert.NotNil(err)
+}
+
+func TestRotateImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewRotateImage(90)(context.Background(), newTestImage(t, 200, 100))
+	assert.Nil(err)
+	assert.Equal(100, img.Width)
+	assert.Equal(200, img.Height)
+}
+
+func TestFlipImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewFlipImage(FlipHorizontal)(context.Background(), newTestImage(t, 200, 100))
+	assert.Nil(err)
+	assert.Equal(200, img.Width)
+	assert.Equal(100, img.Height)
+
+	_, err = NewFlipImage("x")(context.Background(), newTestImage(t, 200, 100))
+	assert.NotNil(err)
+}
+
+func TestParseTransform(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := parseCrop([]string{"crop", "0", "0", "10"}, nil)
+	assert.NotNil(err)
+	_, err = parseCrop([]string{"crop", "0", "0", "0", "10"}, nil)
+	assert.NotNil(err)
+	_, err = parseCrop([]string{"crop", "0", "0", "10", "10"}, nil)
+	assert.Nil(err)
+
+	_, err = parseRotate([]string{"rotate", "a"}, nil)
+	assert.NotNil(err)
+	_, err = parseRotate([]string{"rotate", "90"}, nil)
+	assert.Nil(err)
+
+	_, err = parseFlip([]string{"flip", "x"}, nil)
+	assert.NotNil(err)
+	_, err = parseFlip([]string{"flip", "v"}, nil)
+	assert.Nil(err)
+}
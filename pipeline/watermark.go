@@ -18,61 +18,117 @@ import (
 	"context"
 	"image"
 	"image/color"
+	"strings"
+	"time"
 
 	"github.com/disintegration/imaging"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/cache"
 	"github.com/vicanso/tiny-site/storage"
 )
 
-func NewWatermark(url string, postion string, angle float64) ImageJob {
-	return func(ctx context.Context, img *storage.Image) (*storage.Image, error) {
-		info, err := storage.GetImageFromURL(ctx, url)
-		if err != nil {
-			return nil, err
-		}
-		watermarkImg, err := info.Image()
-		if err != nil {
-			return nil, err
+// 水印图片缓存，避免每次都重新拉取水印图片
+var watermarkCache = cache.NewLRUCache(100, 5*time.Minute)
+
+// getWatermarkImage 获取水印图片，source为http(s)地址或bucket:name
+func getWatermarkImage(ctx context.Context, source string) (image.Image, error) {
+	value, ok := watermarkCache.Get(source)
+	if ok {
+		if img, ok := value.(image.Image); ok {
+			return img, nil
 		}
-		if angle != 0 {
-			watermarkImg = imaging.Rotate(watermarkImg, angle, color.Transparent)
+	}
+	var info *storage.Image
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		info, err = storage.GetImageFromURL(ctx, source)
+	} else {
+		arr := strings.SplitN(source, ":", 2)
+		if len(arr) != 2 || arr[0] == "" || arr[1] == "" {
+			return nil, hes.New("watermark source is invalid")
 		}
-		dst, err := decodeImage(img)
-		if err != nil {
-			return nil, err
+		info, err = NewGetEntImage(arr[0], arr[1])(ctx, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	img, err := info.Image()
+	if err != nil {
+		return nil, err
+	}
+	watermarkCache.Add(source, img)
+	return img, nil
+}
+
+// purgeWatermarkCache 清除使用该图片(所有版本)作为水印的缓存
+func purgeWatermarkCache(bucket, name string) {
+	prefix := bucket + ":"
+	for _, key := range watermarkCache.Keys() {
+		source, ok := key.(string)
+		if !ok || !strings.HasPrefix(source, prefix) {
+			continue
 		}
-		x := 0
-		y := 0
-		watermarkWidth := watermarkImg.Bounds().Dx()
-		watermarkHeight := watermarkImg.Bounds().Dy()
-		switch postion {
-		case PositionTop:
-			x = (img.Width - watermarkWidth) / 2
-		case PositionTopRight:
-			x = img.Width - watermarkWidth
-		case PositionLeft:
-			y = (img.Height - watermarkHeight) / 2
-		case PositionCenter:
-			x = (img.Width - watermarkWidth) / 2
-			y = (img.Height - watermarkHeight) / 2
-		case PositionRight:
-			y = (img.Height - watermarkHeight) / 2
-			x = img.Width - watermarkWidth
-		case PositionBottomLeft:
-			y = img.Height - watermarkHeight
-		case PositionBottom:
-			x = (img.Width - watermarkWidth) / 2
-			y = img.Height - watermarkHeight
-		case PositionBottomRight:
-			x = img.Width - watermarkWidth
-			y = img.Height - watermarkHeight
+		if imageSourceName(source[len(prefix):]) == name {
+			watermarkCache.Remove(key)
 		}
-		dst = imaging.Paste(dst, watermarkImg, image.Pt(x, y))
-		data, err := encodeImage(dst, img.Type)
+	}
+}
+
+// getWatermarkPosition 根据位置计算水印的左上角坐标
+func getWatermarkPosition(position string, width, height, watermarkWidth, watermarkHeight, margin int) image.Point {
+	x := margin
+	y := margin
+	centerX := (width - watermarkWidth) / 2
+	centerY := (height - watermarkHeight) / 2
+	right := width - watermarkWidth - margin
+	bottom := height - watermarkHeight - margin
+	switch position {
+	case PositionTop:
+		x = centerX
+	case PositionTopRight:
+		x = right
+	case PositionLeft:
+		y = centerY
+	case PositionCenter:
+		x = centerX
+		y = centerY
+	case PositionRight:
+		x = right
+		y = centerY
+	case PositionBottomLeft:
+		y = bottom
+	case PositionBottom:
+		x = centerX
+		y = bottom
+	case PositionBottomRight:
+		x = right
+		y = bottom
+	}
+	return image.Pt(x, y)
+}
+
+// NewWatermark 添加水印，source为http(s)地址或bucket:name，opacity为0-1
+func NewWatermark(source, position string, angle, opacity float64, margin int) ImageJob {
+	return func(ctx context.Context, img *storage.Image) (*storage.Image, error) {
+		watermarkImg, err := getWatermarkImage(ctx, source)
 		if err != nil {
 			return nil, err
 		}
-		img.SetData(data)
-
-		return img, nil
+		if angle != 0 {
+			watermarkImg = imaging.Rotate(watermarkImg, angle, color.Transparent)
+		}
+		watermarkBounds := watermarkImg.Bounds()
+		return transform(img, func(dst image.Image) (image.Image, error) {
+			bounds := dst.Bounds()
+			pt := getWatermarkPosition(
+				position,
+				bounds.Dx(),
+				bounds.Dy(),
+				watermarkBounds.Dx(),
+				watermarkBounds.Dy(),
+				margin,
+			)
+			return imaging.Overlay(dst, watermarkImg, pt, opacity), nil
+		})
 	}
 }
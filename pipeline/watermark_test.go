@@ -0,0 +1,104 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/hes"
+)
+
+func TestGetWatermarkPosition(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		position string
+		pt       image.Point
+	}{
+		{
+			position: PositionTopLeft,
+			pt:       image.Pt(10, 10),
+		},
+		{
+			position: PositionTop,
+			pt:       image.Pt(40, 10),
+		},
+		{
+			position: PositionTopRight,
+			pt:       image.Pt(70, 10),
+		},
+		{
+			position: PositionLeft,
+			pt:       image.Pt(10, 90),
+		},
+		{
+			position: PositionCenter,
+			pt:       image.Pt(40, 90),
+		},
+		{
+			position: PositionRight,
+			pt:       image.Pt(70, 90),
+		},
+		{
+			position: PositionBottomLeft,
+			pt:       image.Pt(10, 170),
+		},
+		{
+			position: PositionBottom,
+			pt:       image.Pt(40, 170),
+		},
+		{
+			position: PositionBottomRight,
+			pt:       image.Pt(70, 170),
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.pt, getWatermarkPosition(tt.position, 100, 200, 20, 20, 10), tt.position)
+	}
+}
+
+func TestParseWatermark(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := parseWatermark([]string{"watermark"}, nil)
+	assert.Equal("watermark params is invalid", err.(*hes.Error).Message)
+
+	_, err = parseWatermark([]string{"watermark", "bucket:name", PositionTop, "0", "2"}, nil)
+	assert.Equal("watermark opacity should be 0-1", err.(*hes.Error).Message)
+
+	job, err := parseWatermark([]string{"watermark", "bucket:name", PositionTop, "45", "0.5", "10"}, nil)
+	assert.Nil(err)
+	assert.NotNil(job)
+}
+
+func TestPurgeWatermarkCache(t *testing.T) {
+	assert := assert.New(t)
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	watermarkCache.Add("test:logo", img)
+	watermarkCache.Add("test:logo@v2", img)
+	watermarkCache.Add("test:logo2", img)
+	defer watermarkCache.Remove("test:logo2")
+
+	purgeWatermarkCache("test", "logo")
+	_, ok := watermarkCache.Get("test:logo")
+	assert.False(ok)
+	_, ok = watermarkCache.Get("test:logo@v2")
+	assert.False(ok)
+	_, ok = watermarkCache.Get("test:logo2")
+	assert.True(ok)
+}
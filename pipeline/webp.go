@@ -0,0 +1,169 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/vicanso/hes"
+)
+
+const (
+	// VP8X中的标记位
+	webpFlagAlpha     = 0x10
+	webpFlagExif      = 0x08
+	webpFlagXMP       = 0x04
+	webpFlagAnimation = 0x02
+	// gif延时为0或1时，浏览器均按100ms展示
+	webpMinFrameDuration = 20
+	webpDefaultDuration  = 100
+)
+
+type webpChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// parseWebpChunks 解析webp(RIFF)中的所有chunk
+func parseWebpChunks(data []byte) ([]webpChunk, error) {
+	if len(data) < 12 ||
+		string(data[0:4]) != "RIFF" ||
+		string(data[8:12]) != "WEBP" {
+		return nil, hes.New("data is not webp")
+	}
+	chunks := make([]webpChunk, 0)
+	offset := 12
+	for offset+8 <= len(data) {
+		fourCC := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		start := offset + 8
+		end := start + size
+		if size < 0 || end > len(data) {
+			return nil, hes.New("webp chunk is invalid")
+		}
+		chunks = append(chunks, webpChunk{
+			fourCC: fourCC,
+			data:   data[start:end],
+		})
+		// chunk的长度为奇数时有一个字节的填充
+		offset = end + size%2
+	}
+	return chunks, nil
+}
+
+func writeWebpChunk(buffer *bytes.Buffer, fourCC string, data []byte) {
+	buffer.WriteString(fourCC)
+	_ = binary.Write(buffer, binary.LittleEndian, uint32(len(data)))
+	buffer.Write(data)
+	if len(data)%2 == 1 {
+		buffer.WriteByte(0)
+	}
+}
+
+func putUint24(buf []byte, v int) {
+	buf[0] = byte(v)
+	buf[1] = byte(v >> 8)
+	buf[2] = byte(v >> 16)
+}
+
+func getUint24(buf []byte) int {
+	return int(buf[0]) | int(buf[1])<<8 | int(buf[2])<<16
+}
+
+// muxAnimatedWebp 将多张静态webp合并为动态webp，
+// delays单位为1/100秒，loopCount与gif的定义一致
+func muxAnimatedWebp(frames [][]byte, delays []int, loopCount, width, height int) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, hes.New("frames of animation can not be empty")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, hes.New("size of animation is invalid")
+	}
+	hasAlpha := false
+	anmf := bytes.Buffer{}
+	for index, frame := range frames {
+		chunks, err := parseWebpChunks(frame)
+		if err != nil {
+			return nil, err
+		}
+		frameData := bytes.Buffer{}
+		// 帧的头部信息：x、y(0)、宽、高、时长以及标记
+		header := make([]byte, 16)
+		var frameWidth, frameHeight int
+		for _, chunk := range chunks {
+			switch chunk.fourCC {
+			case "VP8X":
+				if len(chunk.data) >= 10 {
+					frameWidth = getUint24(chunk.data[4:]) + 1
+					frameHeight = getUint24(chunk.data[7:]) + 1
+				}
+			case "ALPH", "VP8L":
+				hasAlpha = true
+				writeWebpChunk(&frameData, chunk.fourCC, chunk.data)
+			case "VP8 ":
+				writeWebpChunk(&frameData, chunk.fourCC, chunk.data)
+			}
+		}
+		if frameData.Len() == 0 {
+			return nil, hes.New("webp frame has no image data")
+		}
+		// 仅有VP8X的才有尺寸信息，其它的均为完整画面
+		if frameWidth == 0 || frameHeight == 0 {
+			frameWidth = width
+			frameHeight = height
+		}
+		duration := webpDefaultDuration
+		if index < len(delays) && delays[index]*10 >= webpMinFrameDuration {
+			duration = delays[index] * 10
+		}
+		putUint24(header[6:], frameWidth-1)
+		putUint24(header[9:], frameHeight-1)
+		putUint24(header[12:], duration)
+		// 不混合，展示后清除为背景色
+		header[15] = 0x03
+		writeWebpChunk(&anmf, "ANMF", append(header, frameData.Bytes()...))
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpFlagAnimation
+	if hasAlpha {
+		vp8x[0] |= webpFlagAlpha
+	}
+	putUint24(vp8x[4:], width-1)
+	putUint24(vp8x[7:], height-1)
+
+	// gif的0为无限循环，-1为不循环，其它则循环loopCount+1次
+	loop := 0
+	if loopCount < 0 {
+		loop = 1
+	} else if loopCount > 0 {
+		loop = loopCount + 1
+	}
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint16(anim[4:], uint16(loop))
+
+	body := bytes.Buffer{}
+	body.WriteString("WEBP")
+	writeWebpChunk(&body, "VP8X", vp8x)
+	writeWebpChunk(&body, "ANIM", anim)
+	body.Write(anmf.Bytes())
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString("RIFF")
+	_ = binary.Write(&buffer, binary.LittleEndian, uint32(body.Len()))
+	buffer.Write(body.Bytes())
+	return buffer.Bytes(), nil
+}
@@ -22,6 +22,8 @@ import (
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog"
 	"github.com/vicanso/go-performance"
+	"github.com/vicanso/tiny-site/cache"
+	"github.com/vicanso/tiny-site/config"
 	"github.com/vicanso/tiny-site/cs"
 	"github.com/vicanso/tiny-site/email"
 	"github.com/vicanso/tiny-site/helper"
@@ -29,6 +31,7 @@ import (
 	"github.com/vicanso/tiny-site/request"
 	routerconcurrency "github.com/vicanso/tiny-site/router_concurrency"
 	"github.com/vicanso/tiny-site/service"
+	"github.com/vicanso/tiny-site/storage"
 	"github.com/vicanso/tiny-site/util"
 )
 
@@ -37,7 +40,11 @@ type (
 	statsTaskFn func() map[string]interface{}
 )
 
-const logCategory = "schedule"
+const (
+	logCategory = "schedule"
+	// 多实例时仅需一个实例执行的任务的锁前缀
+	lockKeyPrefix = "schedule-lock-"
+)
 
 func init() {
 	c := cron.New()
@@ -52,6 +59,8 @@ func init() {
 	_, _ = c.AddFunc("@every 1m", performanceStats)
 	_, _ = c.AddFunc("@every 1m", httpInstanceStats)
 	_, _ = c.AddFunc("@every 1m", routerConcurrencyStats)
+	_, _ = c.AddFunc("@every 1m", storageHealthCheck)
+	_, _ = c.AddFunc("@every 1h", purgeTrashedImages)
 	// 如果是开发环境，则不执行定时任务
 	if util.IsDevelopment() {
 		return
@@ -78,6 +87,27 @@ func doTask(desc string, fn taskFn) {
 	}
 }
 
+// doTaskWithLock 通过redis锁保证在ttl内仅有一个实例执行任务，
+// 锁不主动释放，因此ttl需要略小于任务的执行间隔
+func doTaskWithLock(desc string, ttl time.Duration, fn taskFn) {
+	ctx := context.Background()
+	key := lockKeyPrefix + desc
+	success, err := cache.GetRedisCache().Lock(ctx, key, ttl)
+	if err != nil {
+		log.Error(ctx).
+			Str("category", logCategory).
+			Str("key", key).
+			Err(err).
+			Msg(desc + " lock fail")
+		return
+	}
+	// 其它实例已执行
+	if !success {
+		return
+	}
+	doTask(desc, fn)
+}
+
 func doStatsTask(desc string, fn statsTaskFn) {
 	startedAt := time.Now()
 	stats := fn()
@@ -95,6 +125,49 @@ func redisPing() {
 func configRefresh() {
 	configSrv := new(service.ConfigurationSrv)
 	doTask("config refresh", configSrv.Refresh)
+	// storage的配置也同时刷新，其它实例更新的配置可生效
+	doTask("storage refresh", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return storage.RefreshStorages(ctx)
+	})
+}
+
+// storageHealthCheck storage的健康检测，由正常转为异常时告警
+func storageHealthCheck() {
+	doTask("storage health check", func() error {
+		ctx := context.Background()
+		statuses, downs := storage.CheckStorages(ctx, 10*time.Second)
+		db := helper.GetInfluxDB()
+		for _, item := range statuses {
+			result := cs.ResultSuccess
+			fields := map[string]interface{}{
+				cs.FieldLatency: item.Latency,
+			}
+			if !item.Healthy {
+				result = cs.ResultFail
+				fields[cs.FieldError] = item.Error
+			}
+			db.Write(cs.MeasurementStorageHealth, map[string]string{
+				cs.TagService:  item.Name,
+				cs.TagCategory: item.Category,
+				cs.TagResult:   strconv.Itoa(result),
+			}, fields)
+		}
+		for _, item := range downs {
+			email.AlarmError(ctx, "storage "+item.Name+" is down, "+item.Error)
+		}
+		return nil
+	})
+}
+
+// purgeTrashedImages 清除回收站中超过保留期限的图片
+func purgeTrashedImages() {
+	doTaskWithLock("purge trashed images", 55*time.Minute, func() error {
+		trashConfig := config.MustGetImageTrashConfig()
+		_, err := storage.PurgeTrashedImages(context.Background(), time.Now().Add(-trashConfig.Retention), trashConfig.BatchSize)
+		return err
+	})
 }
 
 func redisStats() {
@@ -20,6 +20,16 @@ import (
 	"entgo.io/ent/schema/index"
 )
 
+// 图片元数据(EXIF等)的处理策略
+const (
+	// 保留所有元数据
+	BucketExifPolicyKeep = "keep"
+	// 移除GPS信息
+	BucketExifPolicyStripGPS = "stripGPS"
+	// 移除除ICC profile以外的所有元数据
+	BucketExifPolicyStripAll = "stripAll"
+)
+
 type Bucket struct {
 	ent.Schema
 }
@@ -47,6 +57,31 @@ func (Bucket) Fields() []ent.Field {
 		field.String("description").
 			NotEmpty().
 			Comment("bucket的描述"),
+		// 不允许时pipeline需要签名才可访问该bucket的图片
+		field.Bool("allow_unsigned").
+			Default(true).
+			Comment("是否允许未签名的pipeline"),
+		// 为空表示图片数据保存在数据库中
+		field.String("storage").
+			Optional().
+			Comment("图片数据保存的storage"),
+		field.String("storage_bucket").
+			Optional().
+			Comment("图片数据在storage中的bucket(gridfs则为collection)"),
+		// 超出的历史版本在替换图片时删除
+		field.Int("max_versions").
+			NonNegative().
+			Default(10).
+			Comment("保留的历史版本数量，为0表示不限制"),
+		// 上传以及pipeline输出的图片均按此策略处理
+		field.Enum("exif_policy").
+			Values(
+				BucketExifPolicyKeep,
+				BucketExifPolicyStripGPS,
+				BucketExifPolicyStripAll,
+			).
+			Default(BucketExifPolicyKeep).
+			Comment("图片元数据的处理策略"),
 	}
 }
 
@@ -61,11 +61,37 @@ func (Image) Fields() []ent.Field {
 		field.String("creator").
 			NotEmpty().
 			Comment("创建者"),
+		// 保存在storage中的图片不再保存数据
 		field.Bytes("data").
+			Optional().
 			Comment("图片数据"),
+		// 为空表示图片数据保存在数据库中
+		field.String("storage").
+			Optional().
+			Comment("图片数据保存的storage"),
+		field.String("storage_bucket").
+			Optional().
+			Comment("图片数据在storage中的bucket"),
+		field.String("storage_key").
+			Optional().
+			Comment("图片数据在storage中的key"),
 		field.String("description").
 			Optional().
 			Comment("图片描述"),
+		// 每次替换图片数据时递增，原有的数据保存为历史版本
+		field.Int("version").
+			Positive().
+			Default(1).
+			Comment("当前版本号"),
+		// 删除的图片先移至回收站，超过保留期限后再清除
+		field.Time("deleted_at").
+			StructTag(`json:"deletedAt,omitempty" sql:"deleted_at"`).
+			Optional().
+			Nillable().
+			Comment("删除时间，不为空表示在回收站中"),
+		field.String("deleter").
+			Optional().
+			Comment("删除者"),
 	}
 }
 
@@ -73,5 +99,6 @@ func (Image) Fields() []ent.Field {
 func (Image) Indexes() []ent.Index {
 	return []ent.Index{
 		index.Fields("bucket", "name").Unique(),
+		index.Fields("deleted_at"),
 	}
 }
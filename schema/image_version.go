@@ -0,0 +1,91 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"net/http"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// ImageVersion 图片的历史版本，替换图片时原有的数据保存为历史版本
+type ImageVersion struct {
+	ent.Schema
+}
+
+// Mixin 图片历史版本的mixin
+func (ImageVersion) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		TimeMixin{},
+	}
+}
+
+func (ImageVersion) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("bucket").
+			NotEmpty().
+			Immutable().
+			Comment("图片所在bucket"),
+		field.String("name").
+			NotEmpty().
+			Immutable().
+			Comment("图片名"),
+		field.Int("version").
+			Positive().
+			Immutable().
+			Comment("版本号"),
+		field.String("type").
+			NotEmpty().
+			Comment("图片类型"),
+		field.Int("size").
+			NonNegative().
+			Comment("图片数据长度"),
+		field.Int("width").
+			NonNegative().
+			Comment("图片宽度"),
+		field.Int("height").
+			NonNegative().
+			Comment("图片高度"),
+		// 由图片数据提取的metadata(EXIF、主色调等)，回滚时与数据一同恢复
+		field.JSON("metadata", &http.Header{}).
+			Optional().
+			Comment("metadata"),
+		field.String("creator").
+			NotEmpty().
+			Comment("该版本的创建者"),
+		// 保存在storage中的图片不再保存数据
+		field.Bytes("data").
+			Optional().
+			Comment("图片数据"),
+		field.String("storage").
+			Optional().
+			Comment("图片数据保存的storage"),
+		field.String("storage_bucket").
+			Optional().
+			Comment("图片数据在storage中的bucket"),
+		field.String("storage_key").
+			Optional().
+			Comment("图片数据在storage中的key"),
+	}
+}
+
+// Indexes 图片历史版本索引
+func (ImageVersion) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("bucket", "name", "version").Unique(),
+	}
+}
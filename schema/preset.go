@@ -0,0 +1,60 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"entgo.io/ent"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// Preset 图片处理的预设，在pipeline中以preset/name引用
+type Preset struct {
+	ent.Schema
+}
+
+func (Preset) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		TimeMixin{},
+	}
+}
+
+func (Preset) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("bucket").
+			NotEmpty().
+			Immutable().
+			Comment("预设所属的bucket"),
+		field.String("name").
+			NotEmpty().
+			Immutable().
+			Comment("预设的名称"),
+		field.Text("tasks").
+			NotEmpty().
+			Comment("预设的任务列表，以|分隔"),
+		field.String("creator").
+			NotEmpty().
+			Comment("预设的创建者"),
+		field.String("description").
+			Optional().
+			Comment("预设的描述"),
+	}
+}
+
+func (Preset) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("bucket", "name").Unique(),
+	}
+}
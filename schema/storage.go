@@ -24,6 +24,8 @@ const (
 	StorageCategoryMinio  = "minio"
 	StorageCategoryOSS    = "oss"
 	StorageCategoryGridfs = "gridfs"
+	StorageCategoryFile   = "file"
+	StorageCategoryS3     = "s3"
 )
 
 type Storage struct {
@@ -49,6 +51,8 @@ func (Storage) Fields() []ent.Field {
 				StorageCategoryMinio,
 				StorageCategoryOSS,
 				StorageCategoryGridfs,
+				StorageCategoryFile,
+				StorageCategoryS3,
 			).
 			Comment("存储类型"),
 		field.Text("uri").
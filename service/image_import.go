@@ -0,0 +1,148 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/util"
+)
+
+const imageImportJobKeyPrefix = "imageImportJob:"
+
+const (
+	// ImageImportCreated 已创建
+	ImageImportCreated = "created"
+	// ImageImportSkipped 已存在同名的图片，跳过
+	ImageImportSkipped = "skipped"
+	// ImageImportRejected 校验不通过或保存失败
+	ImageImportRejected = "rejected"
+)
+
+const (
+	// ImageImportJobRunning 处理中
+	ImageImportJobRunning = "running"
+	// ImageImportJobDone 已完成
+	ImageImportJobDone = "done"
+	// ImageImportJobFailed 处理失败(如压缩包无法读取)
+	ImageImportJobFailed = "failed"
+)
+
+var ErrImageImportJobNotFound = hes.NewWithStatusCode("import job is not found", http.StatusNotFound)
+
+type (
+	// ImageImportResult 单个文件的导入结果
+	ImageImportResult struct {
+		// 文件路径
+		File string `json:"file"`
+		// 图片名称
+		Name string `json:"name,omitempty"`
+		// 结果：created、skipped、rejected
+		Result string `json:"result"`
+		// 跳过或拒绝的原因
+		Reason string `json:"reason,omitempty"`
+	}
+	// ImageImportJob 导入图片的任务
+	ImageImportJob struct {
+		// 异步任务的id，同步处理的为空
+		ID      string `json:"id,omitempty"`
+		Bucket  string `json:"bucket"`
+		Creator string `json:"creator"`
+		Status  string `json:"status"`
+		// 任务失败的原因
+		Error string `json:"error,omitempty"`
+
+		// 文件总数
+		Total     int `json:"total"`
+		Processed int `json:"processed"`
+		Created   int `json:"created"`
+		Skipped   int `json:"skipped"`
+		Rejected  int `json:"rejected"`
+
+		Results []ImageImportResult `json:"results"`
+
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+)
+
+// NewImageImportJob 创建导入图片的任务，async为true则生成任务id
+func NewImageImportJob(bucket, creator string, total int, async bool) *ImageImportJob {
+	now := time.Now()
+	job := &ImageImportJob{
+		Bucket:    bucket,
+		Creator:   creator,
+		Status:    ImageImportJobRunning,
+		Total:     total,
+		Results:   make([]ImageImportResult, 0, total),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if async {
+		job.ID = util.GenXID()
+	}
+	return job
+}
+
+// Add 添加单个文件的导入结果
+func (job *ImageImportJob) Add(result ImageImportResult) {
+	job.Processed++
+	switch result.Result {
+	case ImageImportCreated:
+		job.Created++
+	case ImageImportSkipped:
+		job.Skipped++
+	default:
+		job.Rejected++
+	}
+	job.Results = append(job.Results, result)
+	job.UpdatedAt = time.Now()
+}
+
+// Done 设置任务完成，err不为空则为失败
+func (job *ImageImportJob) Done(err error) {
+	job.Status = ImageImportJobDone
+	if err != nil {
+		job.Status = ImageImportJobFailed
+		job.Error = hes.Wrap(err).Message
+	}
+	job.UpdatedAt = time.Now()
+}
+
+// Save 保存任务的状态，用于查询进度
+func (job *ImageImportJob) Save(ctx context.Context, ttl time.Duration) error {
+	return redisSrv.SetStruct(ctx, imageImportJobKeyPrefix+job.ID, job, ttl)
+}
+
+// GetImageImportJob 获取导入图片的任务
+func GetImageImportJob(ctx context.Context, id string) (*ImageImportJob, error) {
+	data, err := redisSrv.GetIgnoreNilErr(ctx, imageImportJobKeyPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrImageImportJobNotFound
+	}
+	job := &ImageImportJob{}
+	err = json.Unmarshal(data, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/vicanso/hes"
+)
+
+// ArchiveFile 压缩包中的文件
+type ArchiveFile struct {
+	// 文件在压缩包中的路径
+	Path string
+	Data []byte
+	// 读取数据出错，如超过长度限制
+	Err error
+}
+
+var errArchiveInvalid = hes.New("archive should be zip, tar, tar.gz or tgz")
+
+// IsArchive 根据文件名判断是否压缩包(zip、tar、tar.gz、tgz)
+func IsArchive(filename string) bool {
+	return isZip(filename) || isTar(filename)
+}
+
+func isZip(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".zip")
+}
+
+func isGzipTar(filename string) bool {
+	name := strings.ToLower(filename)
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+func isTar(filename string) bool {
+	return isGzipTar(filename) || strings.HasSuffix(strings.ToLower(filename), ".tar")
+}
+
+// cleanArchivePath 统一路径分隔符，并去除路径中的..
+func cleanArchivePath(file string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(file, "\\", "/")), "/")
+}
+
+// isIgnoredArchiveFile 忽略隐藏文件以及macOS生成的元数据
+func isIgnoredArchiveFile(file string) bool {
+	if strings.HasPrefix(file, "__MACOSX/") {
+		return true
+	}
+	return strings.HasPrefix(path.Base(file), ".")
+}
+
+// ImageNameFromPath 根据文件路径生成图片名称，
+// 去除扩展名，目录的分隔符替换为-
+func ImageNameFromPath(file string) string {
+	file = cleanArchivePath(file)
+	file = strings.TrimSuffix(file, path.Ext(file))
+	return strings.ReplaceAll(file, "/", "-")
+}
+
+// WalkArchive 遍历压缩包中的文件(忽略目录与隐藏文件)，fn返回出错则中止。
+// 单个文件读取出错(如超过长度限制)时不中止，由ArchiveFile.Err返回
+func WalkArchive(filename string, r io.ReaderAt, size int64, fn func(file ArchiveFile) error) error {
+	if isZip(filename) {
+		return walkZip(r, size, fn)
+	}
+	if isTar(filename) {
+		return walkTar(filename, io.NewSectionReader(r, 0, size), fn)
+	}
+	return errArchiveInvalid
+}
+
+// CountArchiveFiles 获取压缩包中的文件数量(忽略目录与隐藏文件)
+func CountArchiveFiles(filename string, r io.ReaderAt, size int64) (int, error) {
+	if isZip(filename) {
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		for _, file := range zr.File {
+			if !file.FileInfo().IsDir() && !isIgnoredArchiveFile(cleanArchivePath(file.Name)) {
+				count++
+			}
+		}
+		return count, nil
+	}
+	if isTar(filename) {
+		count := 0
+		err := doWalkTar(filename, io.NewSectionReader(r, 0, size), func(_ *tar.Reader, _ string) error {
+			count++
+			return nil
+		})
+		return count, err
+	}
+	return 0, errArchiveInvalid
+}
+
+func walkZip(r io.ReaderAt, size int64, fn func(file ArchiveFile) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		name := cleanArchivePath(file.Name)
+		if file.FileInfo().IsDir() || isIgnoredArchiveFile(name) {
+			continue
+		}
+		item := ArchiveFile{
+			Path: name,
+		}
+		rc, err := file.Open()
+		if err == nil {
+			item.Data, err = ReadImageData(rc)
+			_ = rc.Close()
+		}
+		item.Err = err
+		err = fn(item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(filename string, r io.Reader, fn func(file ArchiveFile) error) error {
+	return doWalkTar(filename, r, func(tr *tar.Reader, name string) error {
+		data, err := ReadImageData(tr)
+		return fn(ArchiveFile{
+			Path: name,
+			Data: data,
+			Err:  err,
+		})
+	})
+}
+
+func doWalkTar(filename string, r io.Reader, fn func(tr *tar.Reader, name string) error) error {
+	if isGzipTar(filename) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := cleanArchivePath(header.Name)
+		if header.Typeflag != tar.TypeReg || isIgnoredArchiveFile(name) {
+			continue
+		}
+		err = fn(tr, name)
+		if err != nil {
+			return err
+		}
+	}
+}
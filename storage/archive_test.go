@@ -0,0 +1,113 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testArchiveFiles = map[string]string{
+	"products/shoe-1.png": "a",
+	"../cover.jpg":        "b",
+	".DS_Store":           "c",
+	"__MACOSX/._cover":    "d",
+}
+
+func newTestZip(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	_, err := w.Create("products/")
+	assert.Nil(t, err)
+	for name, data := range testArchiveFiles {
+		f, err := w.Create(name)
+		assert.Nil(t, err)
+		_, err = f.Write([]byte(data))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+	return buf.Bytes()
+}
+
+func newTestTarGz(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	w := tar.NewWriter(gw)
+	assert.Nil(t, w.WriteHeader(&tar.Header{
+		Name:     "products/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+	}))
+	for name, data := range testArchiveFiles {
+		assert.Nil(t, w.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(data)),
+		}))
+		_, err := w.Write([]byte(data))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+	assert.Nil(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestImageNameFromPath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("shoe-1", ImageNameFromPath("shoe-1.png"))
+	assert.Equal("products-shoe-1", ImageNameFromPath("products/shoe-1.png"))
+	assert.Equal("products-shoe-1", ImageNameFromPath("products\\shoe-1.png"))
+	assert.Equal("cover", ImageNameFromPath("../../cover.jpg"))
+}
+
+func TestWalkArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(IsArchive("a.ZIP"))
+	assert.True(IsArchive("a.tgz"))
+	assert.False(IsArchive("a.png"))
+
+	for filename, data := range map[string][]byte{
+		"images.zip":    newTestZip(t),
+		"images.tar.gz": newTestTarGz(t),
+	} {
+		r := bytes.NewReader(data)
+		count, err := CountArchiveFiles(filename, r, r.Size())
+		assert.Nil(err)
+		assert.Equal(2, count, filename)
+
+		files := make(map[string]string)
+		err = WalkArchive(filename, r, r.Size(), func(file ArchiveFile) error {
+			assert.Nil(file.Err)
+			files[file.Path] = string(file.Data)
+			return nil
+		})
+		assert.Nil(err)
+		assert.Equal(map[string]string{
+			"products/shoe-1.png": "a",
+			"cover.jpg":           "b",
+		}, files, filename)
+	}
+
+	_, err := CountArchiveFiles("images.rar", bytes.NewReader(nil), 0)
+	assert.Equal(errArchiveInvalid, err)
+}
@@ -0,0 +1,322 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/vicanso/hes"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	oss "github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// Blob 图片数据的存储，bucket为storage中的bucket(gridfs为collection，本地文件为子目录)
+type Blob interface {
+	Get(ctx context.Context, bucket, key string) ([]byte, error)
+	Put(ctx context.Context, bucket, key string, data []byte) error
+	Delete(ctx context.Context, bucket, key string) error
+}
+
+var ErrBlobNotFound = hes.New("blob of storage is not found")
+
+// 记录所有storage的blob
+var blobs = sync.Map{}
+
+// GetBlob 获取storage对应的blob
+func GetBlob(name string) (Blob, error) {
+	value, ok := blobs.Load(name)
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	b, ok := value.(Blob)
+	if !ok {
+		return nil, hes.New("blob of storage is invalid")
+	}
+	return b, nil
+}
+
+// RegisterBlob 注册storage的blob，已存在则替换
+func RegisterBlob(name string, b Blob) {
+	blobs.Store(name, b)
+}
+
+// UnregisterBlob 删除storage的blob
+func UnregisterBlob(name string) {
+	blobs.Delete(name)
+}
+
+// minioBlob 保存至minio
+type minioBlob struct {
+	client *minio.Client
+}
+
+func (b *minioBlob) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	obj, err := b.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return ReadImageData(obj)
+}
+
+func (b *minioBlob) Put(ctx context.Context, bucket, key string, data []byte) error {
+	_, err := b.client.PutObject(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	return err
+}
+
+func (b *minioBlob) Delete(ctx context.Context, bucket, key string) error {
+	return b.client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{})
+}
+
+// ossBlob 保存至oss
+type ossBlob struct {
+	client *oss.Client
+}
+
+func (b *ossBlob) Get(_ context.Context, bucket, key string) ([]byte, error) {
+	ossBucket, err := b.client.Bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ossBucket.GetObject(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ReadImageData(r)
+}
+
+func (b *ossBlob) Put(_ context.Context, bucket, key string, data []byte) error {
+	ossBucket, err := b.client.Bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return ossBucket.PutObject(key, bytes.NewReader(data))
+}
+
+func (b *ossBlob) Delete(_ context.Context, bucket, key string) error {
+	ossBucket, err := b.client.Bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return ossBucket.DeleteObject(key)
+}
+
+// gridfsBlob 保存至gridfs，以key作为文件名
+type gridfsBlob struct {
+	client   *mongo.Client
+	database string
+}
+
+func (b *gridfsBlob) bucket(name string) (*gridfs.Bucket, error) {
+	db := b.client.Database(b.database)
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(name))
+}
+
+func (b *gridfsBlob) Get(_ context.Context, bucket, key string) ([]byte, error) {
+	gb, err := b.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := gb.OpenDownloadStreamByName(key)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return ReadImageData(stream)
+}
+
+func (b *gridfsBlob) Put(ctx context.Context, bucket, key string, data []byte) error {
+	gb, err := b.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	// 文件名可重复，因此先删除旧的文件
+	err = b.Delete(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	_, err = gb.UploadFromStream(key, bytes.NewReader(data))
+	return err
+}
+
+func (b *gridfsBlob) Delete(ctx context.Context, bucket, key string) error {
+	gb, err := b.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	cursor, err := gb.Find(bson.M{
+		"filename": key,
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var file struct {
+			ID interface{} `bson:"_id"`
+		}
+		err = cursor.Decode(&file)
+		if err != nil {
+			return err
+		}
+		err = gb.Delete(file.ID)
+		if err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+var errFilePathInvalid = hes.New("path of file is invalid")
+
+// fileBlob 保存至本地文件，路径为root/bucket/key
+type fileBlob struct {
+	root string
+	// 根目录解析软链接后的路径
+	realRoot string
+}
+
+func newFileBlob(uri string) (*fileBlob, error) {
+	urlInfo, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	root := urlInfo.Path
+	if root == "" {
+		return nil, hes.New("root dir of file storage can not be empty")
+	}
+	err = os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	return &fileBlob{
+		root:     root,
+		realRoot: realRoot,
+	}, nil
+}
+
+// ping 检测根目录是否存在
+func (b *fileBlob) ping(_ context.Context) error {
+	info, err := os.Stat(b.root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return hes.New("root of file storage is not a dir")
+	}
+	return nil
+}
+
+// file 获取文件路径，不允许超出根目录
+func (b *fileBlob) file(bucket, key string) (string, error) {
+	file := filepath.Join(b.root, bucket, key)
+	if !strings.HasPrefix(file, b.root+string(filepath.Separator)) {
+		return "", errFilePathInvalid
+	}
+	return file, nil
+}
+
+// resolve 获取相对路径对应的文件，不允许为绝对路径或包括..，
+// 而且解析软链接后也不允许超出根目录
+func (b *fileBlob) resolve(name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) {
+		return "", errFilePathInvalid
+	}
+	for _, item := range strings.Split(filepath.ToSlash(name), "/") {
+		if item == ".." {
+			return "", errFilePathInvalid
+		}
+	}
+	file, err := filepath.EvalSymlinks(filepath.Join(b.root, name))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(file, b.realRoot+string(filepath.Separator)) {
+		return "", errFilePathInvalid
+	}
+	return file, nil
+}
+
+func (b *fileBlob) Get(_ context.Context, bucket, key string) ([]byte, error) {
+	file, err := b.file(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadImageData(f)
+}
+
+func (b *fileBlob) Put(_ context.Context, bucket, key string, data []byte) error {
+	file, err := b.file(bucket, key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	// 先写入临时文件再重命名，避免读取到不完整的数据
+	f, err := os.CreateTemp(filepath.Dir(file), ".blob-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+func (b *fileBlob) Delete(_ context.Context, bucket, key string) error {
+	file, err := b.file(bucket, key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
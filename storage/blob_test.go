@@ -0,0 +1,63 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileBlob(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newFileBlob("file://")
+	assert.NotNil(err)
+
+	b, err := newFileBlob("file://" + t.TempDir())
+	assert.Nil(err)
+
+	ctx := context.Background()
+	data := []byte("abcd")
+	err = b.Put(ctx, "test", "a/b.png", data)
+	assert.Nil(err)
+
+	buf, err := b.Get(ctx, "test", "a/b.png")
+	assert.Nil(err)
+	assert.Equal(data, buf)
+
+	// 不允许超出根目录
+	err = b.Put(ctx, "..", "b.png", data)
+	assert.NotNil(err)
+	_, err = b.Get(ctx, "test", "../../../etc/passwd")
+	assert.NotNil(err)
+
+	err = b.Delete(ctx, "test", "a/b.png")
+	assert.Nil(err)
+	_, err = b.Get(ctx, "test", "a/b.png")
+	assert.True(os.IsNotExist(err))
+	// 删除不存在的文件不出错
+	assert.Nil(b.Delete(ctx, "test", "a/b.png"))
+
+	blobs.Store("file-test", b)
+	defer blobs.Delete("file-test")
+	result, err := GetBlob("file-test")
+	assert.Nil(err)
+	assert.Equal(b, result)
+	_, err = GetBlob("not-exists")
+	assert.Equal(ErrBlobNotFound, err)
+}
@@ -16,10 +16,15 @@ package storage
 
 import (
 	"context"
+	"strings"
 
+	"github.com/iancoleman/strcase"
+	"github.com/vicanso/hes"
 	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/bucket"
 	"github.com/vicanso/tiny-site/ent/image"
 	"github.com/vicanso/tiny-site/helper"
+	"github.com/vicanso/tiny-site/util"
 )
 
 type entStorage struct {
@@ -32,43 +37,71 @@ func mustNewEntStorage() ImageStorage {
 	}
 }
 
-// Get gets image from ent(mysql or postgres)
+// Get gets image from ent(mysql or postgres),
+// the data is loaded from the storage if it is saved in storage,
+// the image in trash is ignored.
+// The version can be specified by name@v3
 func (e *entStorage) Get(ctx context.Context, bucket, name string) (*ent.Image, error) {
-	return e.client.Image.Query().
+	name, version, err := ParseImageVersion(name)
+	if err != nil {
+		return nil, err
+	}
+	result, err := e.client.Image.Query().
 		Where(image.BucketEQ(bucket)).
 		Where(image.NameEQ(name)).
+		Where(image.DeletedAtIsNil()).
 		First(ctx)
-}
-
-func (e *entStorage) update(ctx context.Context, data ent.Image) error {
-	updateOne := e.client.Image.UpdateOneID(data.ID)
-	if data.Bucket != "" {
-		updateOne.SetBucket(data.Bucket)
+	if err != nil {
+		return nil, err
 	}
-	if data.Name != "" {
-		updateOne.SetName(data.Name)
+	// 非当前版本则从历史版本中获取
+	if version != 0 && version != result.Version {
+		return GetImageVersion(ctx, bucket, name, version)
 	}
-	if data.Type != "" {
-		updateOne.SetType(data.Type)
+	data, err := GetImageData(ctx, result)
+	if err != nil {
+		return nil, err
 	}
-	if data.Width != 0 {
-		updateOne.SetWidth(data.Width)
+	result.Data = data
+	return result, nil
+}
+
+// GetBucketExifPolicy 获取bucket设置的元数据处理策略
+func GetBucketExifPolicy(ctx context.Context, bucketName string) (string, error) {
+	result, err := helper.EntGetClient().Bucket.Query().
+		Where(bucket.Name(bucketName)).
+		First(ctx)
+	if err != nil {
+		return "", err
+	}
+	return result.ExifPolicy.String(), nil
+}
+
+func (e *entStorage) update(ctx context.Context, data ent.Image) error {
+	if len(data.Data) == 0 {
+		updateOne := e.client.Image.UpdateOneID(data.ID)
+		setImageFields(updateOne.Mutation(), data)
+		_, err := updateOne.Save(ctx)
+		return err
 	}
-	if data.Height != 0 {
-		updateOne.SetHeight(data.Height)
+	current, err := e.client.Image.Get(ctx, data.ID)
+	if err != nil {
+		return err
 	}
-	if data.Metadata != nil {
-		updateOne.SetMetadata(data.Metadata)
+	bucket := current.Bucket
+	if data.Bucket != "" {
+		bucket = data.Bucket
 	}
-	if len(data.Tags) != 0 {
-		updateOne.SetTags(data.Tags)
+	name := current.Name
+	if data.Name != "" {
+		name = data.Name
 	}
-	size := len(data.Data)
-	if size != 0 {
-		updateOne.SetData(data.Data)
-		updateOne.SetSize(size)
+	ref, err := SaveImageBlob(ctx, bucket, name, data.Data)
+	if err != nil {
+		return err
 	}
-	_, err := updateOne.Save(ctx)
+	// 更新数据时原有的数据保存为历史版本
+	_, err = replaceImage(ctx, current, ref, data)
 	return err
 }
 
@@ -78,27 +111,167 @@ func (e *entStorage) Put(ctx context.Context, data ent.Image) error {
 	if data.ID != 0 {
 		return e.update(ctx, data)
 	}
-	_, err := e.client.Image.Create().
+	err := CheckImageNotTrashed(ctx, data.Bucket, data.Name)
+	if err != nil {
+		return err
+	}
+	ref, err := SaveImageBlob(ctx, data.Bucket, data.Name, data.Data)
+	if err != nil {
+		return err
+	}
+	create := e.client.Image.Create().
 		SetBucket(data.Bucket).
 		SetName(data.Name).
 		SetType(data.Type).
-		SetSize(len(data.Data)).
 		SetWidth(data.Width).
 		SetHeight(data.Height).
 		SetMetadata(data.Metadata).
 		SetCreator(data.Creator).
-		SetData(data.Data).
-		SetTags(data.Tags).
-		Save(ctx)
-	return err
+		SetTags(data.Tags)
+	SetImageBlob(create.Mutation(), ref, data.Data)
+	_, err = create.Save(ctx)
+	if err != nil {
+		DeleteImageBlob(ctx, ref)
+		return err
+	}
+	return nil
+}
+
+// listParams 转换为公共的列表查询参数，排序与字段均需为有效的字段
+func (params *ImageFilterParams) listParams() (*helper.EntListParams, error) {
+	listParams := &helper.EntListParams{
+		Limit:  params.Limit,
+		Offset: params.Offset,
+		Fields: params.Fields,
+		Order:  params.Order,
+	}
+	if listParams.Order == "" {
+		listParams.Order = "-id"
+	}
+	for _, item := range strings.Split(listParams.Order, ",") {
+		if !image.ValidColumn(strcase.ToSnake(strings.TrimPrefix(item, "-"))) {
+			return nil, hes.New("order of image is invalid: " + item)
+		}
+	}
+	for _, field := range listParams.GetFields() {
+		if !image.ValidColumn(field) {
+			return nil, hes.New("field of image is invalid: " + field)
+		}
+	}
+	return listParams, nil
+}
+
+// fields 获取查询的字段，未指定返回图片数据时不查询data
+func (params *ImageFilterParams) fields(listParams *helper.EntListParams) []string {
+	fields := listParams.GetFields()
+	if len(fields) == 0 {
+		fields = image.Columns
+	}
+	result := make([]string, 0, len(fields)+3)
+	withData := false
+	for _, field := range fields {
+		if field == image.FieldData {
+			if !params.WithData {
+				continue
+			}
+			withData = true
+		}
+		result = append(result, field)
+	}
+	// 图片数据可能保存在storage中，因此需要查询其位置
+	if withData {
+		for _, field := range []string{
+			image.FieldStorage,
+			image.FieldStorageBucket,
+			image.FieldStorageKey,
+		} {
+			if !util.ContainsString(result, field) {
+				result = append(result, field)
+			}
+		}
+	}
+	return result
+}
+
+func (params *ImageFilterParams) where(query *ent.ImageQuery) *ent.ImageQuery {
+	// 回收站中的图片不查询
+	query.Where(image.DeletedAtIsNil())
+	if params.Bucket != "" {
+		query.Where(image.BucketEQ(params.Bucket))
+	}
+	if params.Tag != "" {
+		query.Where(image.TagsContains(params.Tag))
+	}
+	if params.Type != "" {
+		query.Where(image.TypeEQ(params.Type))
+	}
+	if params.Creator != "" {
+		query.Where(image.CreatorEQ(params.Creator))
+	}
+	if params.MinSize > 0 {
+		query.Where(image.SizeGTE(params.MinSize))
+	}
+	if params.MaxSize > 0 {
+		query.Where(image.SizeLTE(params.MaxSize))
+	}
+	if params.MinWidth > 0 {
+		query.Where(image.WidthGTE(params.MinWidth))
+	}
+	if params.MaxWidth > 0 {
+		query.Where(image.WidthLTE(params.MaxWidth))
+	}
+	if params.MinHeight > 0 {
+		query.Where(image.HeightGTE(params.MinHeight))
+	}
+	if params.MaxHeight > 0 {
+		query.Where(image.HeightLTE(params.MaxHeight))
+	}
+	if !params.CreatedAtFrom.IsZero() {
+		query.Where(image.CreatedAtGTE(params.CreatedAtFrom))
+	}
+	if !params.CreatedAtTo.IsZero() {
+		query.Where(image.CreatedAtLTE(params.CreatedAtTo))
+	}
+	return query
 }
 
 // Query gets the files from ent(mysql or postgres)
-func (e *entStorage) Query(ctx context.Context, param ImageFilterParams) ([]*ent.Image, error) {
-	return nil, nil
+func (e *entStorage) Query(ctx context.Context, params ImageFilterParams) ([]*ent.Image, error) {
+	listParams, err := params.listParams()
+	if err != nil {
+		return nil, err
+	}
+	query := e.client.Image.Query().
+		Limit(listParams.GetLimit()).
+		Offset(listParams.GetOffset()).
+		Order(listParams.GetOrders()...)
+	params.where(query)
+	result := make([]*ent.Image, 0)
+	err = query.Select(params.fields(listParams)...).Scan(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+	if params.WithData {
+		for _, item := range result {
+			if GetImageBlobRef(item) == nil {
+				continue
+			}
+			item.Data, err = GetImageData(ctx, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
 }
 
 // Count counts the files from ent(mysql or postgres)
 func (e *entStorage) Count(ctx context.Context, params ImageFilterParams) (int64, error) {
-	return -1, nil
+	query := e.client.Image.Query()
+	params.where(query)
+	count, err := query.Count(ctx)
+	if err != nil {
+		return -1, err
+	}
+	return int64(count), nil
 }
@@ -0,0 +1,258 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/image"
+	"github.com/vicanso/tiny-site/helper"
+)
+
+// newTestImages 创建用于查询的测试图片，其中trashed在回收站中
+func newTestImages(t *testing.T) string {
+	assert := assert.New(t)
+	ctx := context.Background()
+	bucketName := newTestBucket(t, "")
+	for _, item := range []ent.Image{
+		{
+			Name:    "a",
+			Type:    "png",
+			Width:   1,
+			Height:  1,
+			Tags:    "cat",
+			Creator: "alice",
+			Data:    []byte("a"),
+		},
+		{
+			Name:    "b",
+			Type:    "jpeg",
+			Width:   2,
+			Height:  2,
+			Tags:    "cat,dog",
+			Creator: "alice",
+			Data:    []byte("bb"),
+		},
+		{
+			Name:    "c",
+			Type:    "png",
+			Width:   3,
+			Height:  3,
+			Tags:    "dog",
+			Creator: "bob",
+			Data:    []byte("ccc"),
+		},
+		{
+			Name:    "trashed",
+			Type:    "png",
+			Width:   1,
+			Height:  1,
+			Tags:    "cat",
+			Creator: "alice",
+			Data:    []byte("d"),
+		},
+	} {
+		item.Bucket = bucketName
+		item.Metadata = &http.Header{}
+		assert.Nil(Ent().Put(ctx, item))
+	}
+	err := helper.EntGetClient().Image.Update().
+		Where(image.Bucket(bucketName), image.Name("trashed")).
+		SetDeletedAt(time.Now()).
+		Exec(ctx)
+	assert.Nil(err)
+	return bucketName
+}
+
+func TestEntImageQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	bucketName := newTestImages(t)
+
+	tests := []struct {
+		name   string
+		params ImageFilterParams
+		names  []string
+		count  int64
+	}{
+		{
+			name:  "bucket",
+			names: []string{"c", "b", "a"},
+			count: 3,
+		},
+		{
+			name: "tag",
+			params: ImageFilterParams{
+				Tag: "cat",
+			},
+			names: []string{"b", "a"},
+			count: 2,
+		},
+		{
+			name: "type",
+			params: ImageFilterParams{
+				Type: "jpeg",
+			},
+			names: []string{"b"},
+			count: 1,
+		},
+		{
+			name: "creator",
+			params: ImageFilterParams{
+				Creator: "bob",
+			},
+			names: []string{"c"},
+			count: 1,
+		},
+		{
+			name: "size",
+			params: ImageFilterParams{
+				MinSize: 2,
+				MaxSize: 2,
+			},
+			names: []string{"b"},
+			count: 1,
+		},
+		{
+			name: "width and height",
+			params: ImageFilterParams{
+				MinWidth:  2,
+				MaxHeight: 2,
+			},
+			names: []string{"b"},
+			count: 1,
+		},
+		{
+			name: "created at",
+			params: ImageFilterParams{
+				CreatedAtFrom: time.Now().Add(time.Hour),
+			},
+			names: []string{},
+			count: 0,
+		},
+		{
+			name: "limit and offset",
+			params: ImageFilterParams{
+				Limit:  1,
+				Offset: 1,
+			},
+			names: []string{"b"},
+			count: 3,
+		},
+		{
+			name: "order",
+			params: ImageFilterParams{
+				Order: "id",
+			},
+			names: []string{"a", "b", "c"},
+			count: 3,
+		},
+	}
+	for _, tt := range tests {
+		params := tt.params
+		params.Bucket = bucketName
+		result, err := Ent().Query(ctx, params)
+		assert.Nil(err, tt.name)
+		names := make([]string, 0, len(result))
+		for _, item := range result {
+			names = append(names, item.Name)
+		}
+		assert.Equal(tt.names, names, tt.name)
+
+		count, err := Ent().Count(ctx, params)
+		assert.Nil(err, tt.name)
+		assert.Equal(tt.count, count, tt.name)
+	}
+}
+
+func TestEntImageQueryFields(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	bucketName := newTestImages(t)
+
+	tests := []struct {
+		name   string
+		params ImageFilterParams
+		err    string
+		width  int
+		data   []byte
+	}{
+		{
+			// 默认不查询图片数据
+			name:  "default",
+			width: 3,
+		},
+		{
+			name: "with data",
+			params: ImageFilterParams{
+				WithData: true,
+			},
+			width: 3,
+			data:  []byte("ccc"),
+		},
+		{
+			// 指定了data但未设置WithData也不查询
+			name: "data field without data",
+			params: ImageFilterParams{
+				Fields: "name,data",
+			},
+		},
+		{
+			name: "fields",
+			params: ImageFilterParams{
+				Fields:   "name,data",
+				WithData: true,
+			},
+			data: []byte("ccc"),
+		},
+		{
+			name: "invalid field",
+			params: ImageFilterParams{
+				Fields: "name,password",
+			},
+			err: "field of image is invalid: password",
+		},
+		{
+			name: "invalid order",
+			params: ImageFilterParams{
+				Order: "-password",
+			},
+			err: "order of image is invalid: -password",
+		},
+	}
+	for _, tt := range tests {
+		params := tt.params
+		params.Bucket = bucketName
+		params.Limit = 1
+		result, err := Ent().Query(ctx, params)
+		if tt.err != "" {
+			assert.NotNil(err, tt.name)
+			assert.Contains(err.Error(), tt.err, tt.name)
+			continue
+		}
+		assert.Nil(err, tt.name)
+		assert.Equal(1, len(result), tt.name)
+		assert.Equal("c", result[0].Name, tt.name)
+		assert.Equal(tt.width, result[0].Width, tt.name)
+		assert.Equal(tt.data, result[0].Data, tt.name)
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vicanso/go-axios"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/ips"
+	"github.com/vicanso/tiny-site/config"
+)
+
+const (
+	// 最多重定向次数
+	fetchMaxRedirects = 5
+)
+
+var (
+	ErrFetchForbidden   = hes.NewWithStatusCode("fetch of the address is forbidden", http.StatusForbidden)
+	ErrFetchTooLarge    = hes.New("size of image is too large")
+	ErrFetchContentType = hes.New("content type of image is invalid")
+)
+
+// fetchGuard 获取外部图片时的校验，包括host与解析后的IP
+type fetchGuard struct {
+	allowHosts []string
+	allowIPs   *ips.IPS
+	denyHosts  []string
+	denyIPs    *ips.IPS
+	maxSize    int64
+}
+
+// splitHostsAndIPs 将列表拆分为host与IP/CIDR
+func splitHostsAndIPs(values []string) ([]string, *ips.IPS, error) {
+	hosts := make([]string, 0)
+	ipList := make([]string, 0)
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		if strings.Contains(value, "/") || net.ParseIP(value) != nil {
+			ipList = append(ipList, value)
+			continue
+		}
+		hosts = append(hosts, value)
+	}
+	result := ips.NewWithoutMutex()
+	err := result.Add(ipList...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hosts, result, nil
+}
+
+func newFetchGuard(allow, deny []string, maxSize int) (*fetchGuard, error) {
+	allowHosts, allowIPs, err := splitHostsAndIPs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyHosts, denyIPs, err := splitHostsAndIPs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &fetchGuard{
+		allowHosts: allowHosts,
+		allowIPs:   allowIPs,
+		denyHosts:  denyHosts,
+		denyIPs:    denyIPs,
+		maxSize:    int64(maxSize),
+	}, nil
+}
+
+// matchHost 判断host是否匹配，*.开头的匹配其子域名
+func matchHost(patterns []string, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *fetchGuard) hasAllowList() bool {
+	return len(g.allowHosts) != 0 || len(g.allowIPs.IPList) != 0 || len(g.allowIPs.IPNetList) != 0
+}
+
+// checkURL 校验地址的协议与host，在请求前以及每次重定向时执行
+func (g *fetchGuard) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrFetchForbidden
+	}
+	host := u.Hostname()
+	if host == "" || matchHost(g.denyHosts, host) {
+		return ErrFetchForbidden
+	}
+	// IP形式的地址直接校验
+	if ip := net.ParseIP(host); ip != nil {
+		return g.checkIP(host, ip.String())
+	}
+	return nil
+}
+
+// checkIP 校验解析后的IP，允许的host不再校验其IP
+func (g *fetchGuard) checkIP(host, ip string) error {
+	if matchHost(g.allowHosts, host) {
+		return nil
+	}
+	if g.denyIPs.Contains(ip) {
+		return ErrFetchForbidden
+	}
+	if g.hasAllowList() && !g.allowIPs.Contains(ip) {
+		return ErrFetchForbidden
+	}
+	return nil
+}
+
+// dialContext 连接时校验实际连接的IP，避免通过dns重新绑定绕过校验
+func (g *fetchGuard) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			ip, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return g.checkIP(host, ip)
+		},
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// limitedBody 限制读取的数据长度，超出时返回出错
+type limitedBody struct {
+	io.ReadCloser
+	remain int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remain <= 0 {
+		// 判断是否还有数据
+		var buf [1]byte
+		n, _ := b.ReadCloser.Read(buf[:])
+		if n != 0 {
+			return 0, ErrFetchTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remain {
+		p = p[:b.remain]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remain -= int64(n)
+	return n, err
+}
+
+// fetchTransport 校验响应的数据类型与长度
+type fetchTransport struct {
+	guard     *fetchGuard
+	transport http.RoundTripper
+}
+
+func (t *fetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.guard.checkURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// 仅校验获取图片的响应，非成功的响应由调用方处理
+	if req.Method != http.MethodGet || resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp, nil
+	}
+	if !isImageContentType(resp.Header.Get("Content-Type")) {
+		resp.Body.Close()
+		return nil, ErrFetchContentType
+	}
+	if resp.ContentLength > t.guard.maxSize {
+		resp.Body.Close()
+		return nil, ErrFetchTooLarge
+	}
+	resp.Body = &limitedBody{
+		ReadCloser: resp.Body,
+		remain:     t.guard.maxSize,
+	}
+	return resp, nil
+}
+
+// isImageContentType 判断是否图片的数据类型，
+// 部分对象存储未设置类型(application/octet-stream)，也允许，由解码时再校验
+func isImageContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "image/") ||
+		mediaType == "application/octet-stream"
+}
+
+func newFetchClient(guard *fetchGuard) *http.Client {
+	transport := &http.Transport{
+		// 不使用代理，避免校验的为代理的地址
+		Proxy:                 nil,
+		DialContext:           guard.dialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Transport: &fetchTransport{
+			guard:     guard,
+			transport: transport,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= fetchMaxRedirects {
+				return hes.New("too many redirects")
+			}
+			return guard.checkURL(req.URL)
+		},
+	}
+}
+
+func mustNewFetchInstance() *axios.Instance {
+	fetchConfig := config.MustGetImageFetchConfig()
+	guard, err := newFetchGuard(fetchConfig.Allow, fetchConfig.Deny, fetchConfig.MaxSize)
+	if err != nil {
+		panic(err)
+	}
+	return axios.NewInstance(&axios.InstanceConfig{
+		Timeout: fetchConfig.Timeout,
+		Client:  newFetchClient(guard),
+	})
+}
+
+// 获取外部图片的实例，校验地址、数据类型以及长度
+var fetchIns = mustNewFetchInstance()
+
+// newStorageHTTPInstance 请求管理员配置的http storage的实例，通常为内网地址，
+// 因此不使用allow/deny的配置，仅允许连接storage的host(包括重定向)，
+// 数据类型以及长度的校验与获取外部图片的一致
+func newStorageHTTPInstance(hosts []string) (*axios.Instance, error) {
+	fetchConfig := config.MustGetImageFetchConfig()
+	guard, err := newFetchGuard(hosts, nil, fetchConfig.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	return axios.NewInstance(&axios.InstanceConfig{
+		Timeout: fetchConfig.Timeout,
+		Client:  newFetchClient(guard),
+	}), nil
+}
+
+// resolveStorageURL 将请求的路径解析为storage的地址，路径需以/开头，
+// 而且解析后的地址需与storage的host一致，避免通过@等方式访问其它的地址
+func resolveStorageURL(base *url.URL, requestURI string) (string, error) {
+	if !strings.HasPrefix(requestURI, "/") || strings.HasPrefix(requestURI, "//") {
+		return "", ErrFetchForbidden
+	}
+	ref, err := url.Parse(requestURI)
+	if err != nil {
+		return "", err
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != base.Scheme || u.Host != base.Host || u.User != nil {
+		return "", ErrFetchForbidden
+	}
+	return u.String(), nil
+}
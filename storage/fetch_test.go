@@ -0,0 +1,204 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchGuardCheckURL(t *testing.T) {
+	assert := assert.New(t)
+
+	guard, err := newFetchGuard(nil, []string{
+		"metadata.google.internal",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"::1/128",
+	}, 1024)
+	assert.Nil(err)
+
+	tests := []struct {
+		url string
+		err error
+	}{
+		{url: "https://example.com/a.png"},
+		{url: "http://8.8.8.8/a.png"},
+		{url: "ftp://example.com/a.png", err: ErrFetchForbidden},
+		{url: "file:///etc/passwd", err: ErrFetchForbidden},
+		{url: "http://metadata.google.internal/", err: ErrFetchForbidden},
+		{url: "http://127.0.0.1:8080/", err: ErrFetchForbidden},
+		{url: "http://169.254.169.254/latest/meta-data/", err: ErrFetchForbidden},
+		{url: "http://[::1]/", err: ErrFetchForbidden},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		assert.Nil(err)
+		assert.Equal(tt.err, guard.checkURL(u), tt.url)
+	}
+}
+
+func TestFetchGuardAllowList(t *testing.T) {
+	assert := assert.New(t)
+
+	guard, err := newFetchGuard([]string{
+		"*.example.com",
+		"10.1.0.0/16",
+	}, []string{
+		"10.0.0.0/8",
+	}, 1024)
+	assert.Nil(err)
+
+	// 允许的host不校验IP
+	assert.Nil(guard.checkIP("img.example.com", "10.2.0.1"))
+	// 允许的IP，但同时也在禁止的列表中
+	assert.Equal(ErrFetchForbidden, guard.checkIP("other.com", "10.1.0.1"))
+	// 不在允许的列表中
+	assert.Equal(ErrFetchForbidden, guard.checkIP("other.com", "8.8.8.8"))
+	assert.Equal(ErrFetchForbidden, guard.checkIP("example.com", "8.8.8.8"))
+}
+
+func TestFetchClient(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+		case "/text":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write(data)
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	// 127.0.0.1被禁止，连接时校验解析的IP
+	guard, err := newFetchGuard(nil, []string{"127.0.0.0/8"}, 1024)
+	assert.Nil(err)
+	client := newFetchClient(guard)
+	u, _ := url.Parse(server.URL)
+	_, err = client.Get("http://localhost:" + u.Port() + "/a.png")
+	assert.True(errors.Is(err, ErrFetchForbidden))
+
+	// 允许访问，但重定向至禁止的地址
+	guard, err = newFetchGuard([]string{"127.0.0.1"}, []string{"169.254.0.0/16"}, 1024)
+	assert.Nil(err)
+	client = newFetchClient(guard)
+	resp, err := client.Get(server.URL + "/a.png")
+	assert.Nil(err)
+	buf, err := io.ReadAll(resp.Body)
+	assert.Nil(err)
+	assert.Equal(data, buf)
+	resp.Body.Close()
+
+	_, err = client.Get(server.URL + "/redirect")
+	assert.True(errors.Is(err, ErrFetchForbidden))
+
+	_, err = client.Get(server.URL + "/text")
+	assert.True(errors.Is(err, ErrFetchContentType))
+
+	// 超出长度限制
+	guard.maxSize = 5
+	_, err = client.Get(server.URL + "/a.png")
+	assert.True(errors.Is(err, ErrFetchTooLarge))
+}
+
+func TestResolveStorageURL(t *testing.T) {
+	assert := assert.New(t)
+
+	base, err := url.Parse("http://127.0.0.1:3000")
+	assert.Nil(err)
+	tests := []struct {
+		uri    string
+		result string
+		err    error
+	}{
+		{uri: "/a.png", result: "http://127.0.0.1:3000/a.png"},
+		{uri: "/images/a.png?w=1", result: "http://127.0.0.1:3000/images/a.png?w=1"},
+		{uri: "/../a.png", result: "http://127.0.0.1:3000/a.png"},
+		{uri: "a.png", err: ErrFetchForbidden},
+		{uri: "@evil.com/a.png", err: ErrFetchForbidden},
+		{uri: "//evil.com/a.png", err: ErrFetchForbidden},
+		{uri: "http://evil.com/a.png", err: ErrFetchForbidden},
+	}
+	for _, tt := range tests {
+		result, err := resolveStorageURL(base, tt.uri)
+		assert.Equal(tt.err, err, tt.uri)
+		assert.Equal(tt.result, result, tt.uri)
+	}
+}
+
+func TestStorageHTTPInstance(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+		case "/text":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write(data)
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	// 仅允许访问storage的host
+	ins, err := newStorageHTTPInstance([]string{"localhost"})
+	assert.Nil(err)
+	u, _ := url.Parse(server.URL)
+	storageURL := "http://localhost:" + u.Port()
+	resp, err := ins.Get(storageURL + "/a.png")
+	assert.Nil(err)
+	assert.Equal(data, resp.Data)
+
+	_, err = ins.Get(storageURL + "/redirect")
+	assert.True(errors.Is(err, ErrFetchForbidden))
+
+	_, err = ins.Get(storageURL + "/text")
+	assert.True(errors.Is(err, ErrFetchContentType))
+
+	_, err = ins.Get(server.URL + "/a.png")
+	assert.True(errors.Is(err, ErrFetchForbidden))
+}
+
+func TestLimitedBody(t *testing.T) {
+	assert := assert.New(t)
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write([]byte("0123456789"))
+		pw.Close()
+	}()
+	// 未设置Content-Length时，读取超出长度则出错
+	_, err := io.ReadAll(&limitedBody{
+		ReadCloser: pr,
+		remain:     5,
+	})
+	assert.Equal(ErrFetchTooLarge, err)
+}
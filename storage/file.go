@@ -0,0 +1,224 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/log"
+	"github.com/vicanso/tiny-site/schema"
+)
+
+// FileChangeListener 本地文件有更新时的回调，file为相对根目录的路径
+type FileChangeListener func(ctx context.Context, storageName, file string) error
+
+var fileChangeListeners = struct {
+	sync.RWMutex
+	fns []FileChangeListener
+}{}
+
+// OnFileChange 添加本地文件更新的回调，需要storage设置了watch=true
+func OnFileChange(fn FileChangeListener) {
+	fileChangeListeners.Lock()
+	defer fileChangeListeners.Unlock()
+	fileChangeListeners.fns = append(fileChangeListeners.fns, fn)
+}
+
+func emitFileChange(storageName, file string) {
+	fileChangeListeners.RLock()
+	fns := fileChangeListeners.fns
+	fileChangeListeners.RUnlock()
+	ctx := context.Background()
+	for _, fn := range fns {
+		err := fn(ctx, storageName, file)
+		if err != nil {
+			log.Error(ctx).
+				Str("storage", storageName).
+				Str("file", file).
+				Err(err).
+				Msg("file change listener fail")
+		}
+	}
+}
+
+// IsFileStorage 判断是否本地文件的storage
+func IsFileStorage(name string) bool {
+	client := loadStorageClient(name)
+	return client != nil && client.category == schema.StorageCategoryFile
+}
+
+// FileCacheSource 本地文件在处理结果缓存中的标识
+func FileCacheSource(storageName, file string) string {
+	return "file:" + storageName + "/" + filepath.ToSlash(filepath.Clean(file))
+}
+
+// newFileStorage 本地文件的storage，uri为根目录，如file:///data/images?watch=true，
+// finder的参数为相对根目录的路径，设置watch=true则在文件更新时触发回调(清除缓存)
+func newFileStorage(name, uri string) (*storageClient, error) {
+	urlInfo, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	b, err := newFileBlob(uri)
+	if err != nil {
+		return nil, err
+	}
+	client := &storageClient{
+		finder: b.find,
+		blob:   b,
+		ping:   b.ping,
+	}
+	if value := urlInfo.Query().Get("watch"); value != "" {
+		watch, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, hes.New("watch of file storage is invalid")
+		}
+		if watch {
+			w, err := newFileWatcher(name, b.realRoot)
+			if err != nil {
+				return nil, err
+			}
+			client.close = w.close
+		}
+	}
+	return client, nil
+}
+
+// find 获取相对根目录的图片，路径中的/会被拆分为多个参数
+func (b *fileBlob) find(_ context.Context, params ...string) (*Image, error) {
+	if len(params) == 0 {
+		return nil, hes.New("file params is invalid")
+	}
+	name, err := url.QueryUnescape(strings.Join(params, "/"))
+	if err != nil {
+		return nil, err
+	}
+	file, err := b.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf, err := ReadImageData(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewImageFromBytes(buf)
+}
+
+// fileWatcher 监听根目录(包括子目录)的文件更新
+type fileWatcher struct {
+	name    string
+	root    string
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+func newFileWatcher(name, root string) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &fileWatcher{
+		name:    name,
+		root:    root,
+		watcher: watcher,
+		done:    make(chan struct{}),
+	}
+	err = w.addDir(root)
+	if err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// addDir fsnotify不支持递归监听，因此需要添加所有子目录
+func (w *fileWatcher) addDir(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return w.watcher.Add(path)
+	})
+}
+
+func (w *fileWatcher) run() {
+	defer close(w.done)
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error(context.Background()).
+				Str("storage", w.name).
+				Err(err).
+				Msg("watch file fail")
+		}
+	}
+}
+
+func (w *fileWatcher) handle(event fsnotify.Event) {
+	if event.Op == fsnotify.Chmod {
+		return
+	}
+	// 新建的目录也需要监听
+	if event.Op&fsnotify.Create != 0 {
+		info, err := os.Stat(event.Name)
+		if err == nil && info.IsDir() {
+			err = w.addDir(event.Name)
+			if err != nil {
+				log.Error(context.Background()).
+					Str("storage", w.name).
+					Str("dir", event.Name).
+					Err(err).
+					Msg("watch dir fail")
+			}
+			return
+		}
+	}
+	file, err := filepath.Rel(w.root, event.Name)
+	if err != nil {
+		return
+	}
+	emitFileChange(w.name, file)
+}
+
+func (w *fileWatcher) close() {
+	_ = w.watcher.Close()
+	<-w.done
+}
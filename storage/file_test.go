@@ -0,0 +1,102 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/schema"
+)
+
+func TestFileStorageFind(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	assert.Nil(os.MkdirAll(filepath.Join(root, "a"), 0755))
+	data := newTestPNG(t)
+	assert.Nil(os.WriteFile(filepath.Join(root, "a", "b c.png"), data, 0600))
+	// 根目录之外的文件
+	assert.Nil(os.WriteFile(filepath.Join(dir, "secret.png"), data, 0600))
+	assert.Nil(os.Symlink(filepath.Join(dir, "secret.png"), filepath.Join(root, "link.png")))
+
+	client, err := newStorageClient("file-test", schema.StorageCategoryFile, "file://"+root)
+	assert.Nil(err)
+	defer closeStorageClient(client)
+
+	ctx := context.Background()
+	img, err := client.finder(ctx, "a", "b%20c.png")
+	assert.Nil(err)
+	assert.Equal(10, img.Width)
+	img, err = client.finder(ctx, "a%2Fb%20c.png")
+	assert.Nil(err)
+	assert.Equal(10, img.Width)
+
+	for _, params := range [][]string{
+		{},
+		{"..", "secret.png"},
+		{"a", "..", "..", "secret.png"},
+		{"%2E%2E%2Fsecret.png"},
+		{"link.png"},
+		{"a", "not-found.png"},
+	} {
+		_, err = client.finder(ctx, params...)
+		assert.NotNil(err, params)
+	}
+	// 绝对路径
+	_, err = client.blob.(*fileBlob).resolve(filepath.Join(dir, "secret.png"))
+	assert.Equal(errFilePathInvalid, err)
+}
+
+func TestFileStorageWatch(t *testing.T) {
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	changes := make(chan string, 10)
+	OnFileChange(func(_ context.Context, storageName, file string) error {
+		if storageName != "file-watch-test" {
+			return nil
+		}
+		select {
+		case changes <- FileCacheSource(storageName, file):
+		default:
+		}
+		return nil
+	})
+
+	_, err := newStorageClient("file-watch-test", schema.StorageCategoryFile, "file://"+root+"?watch=abc")
+	assert.NotNil(err)
+
+	client, err := newStorageClient("file-watch-test", schema.StorageCategoryFile, "file://"+root+"?watch=true")
+	assert.Nil(err)
+	defer closeStorageClient(client)
+
+	// 新建的子目录也会监听
+	assert.Nil(os.MkdirAll(filepath.Join(root, "a"), 0755))
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(os.WriteFile(filepath.Join(root, "a", "b.png"), newTestPNG(t), 0600))
+
+	select {
+	case source := <-changes:
+		assert.Equal("file:file-watch-test/a/b.png", source)
+	case <-time.After(3 * time.Second):
+		assert.Fail("wait for file change timeout")
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+
+	"github.com/vicanso/hes"
+)
+
+// Animation 动图的所有帧，每帧均为合成后的完整画面
+type Animation struct {
+	// 所有帧
+	Frames []image.Image
+	// 每帧的延时，单位为1/100秒
+	Delays []int
+	// 每帧的调色板，用于重新编码
+	Palettes []color.Palette
+	// 循环次数，与gif的定义一致，0表示无限循环，-1表示不循环
+	LoopCount int
+}
+
+// WithFrames 使用新的帧生成动图，其它属性不变
+func (a *Animation) WithFrames(frames []image.Image) *Animation {
+	return &Animation{
+		Frames:    frames,
+		Delays:    a.Delays,
+		Palettes:  a.Palettes,
+		LoopCount: a.LoopCount,
+	}
+}
+
+var errGIFInvalid = hes.New("data of gif is invalid")
+
+// skipGIFSubBlocks 跳过gif的数据子块，返回之后的位置
+func skipGIFSubBlocks(data []byte, offset int) (int, error) {
+	for {
+		if offset >= len(data) {
+			return 0, errGIFInvalid
+		}
+		size := int(data[offset])
+		offset++
+		if size == 0 {
+			return offset, nil
+		}
+		offset += size
+	}
+}
+
+// gifFrameCount 仅解析gif的块结构(不解压图像数据)获取帧数，
+// 用于解码前按所有帧的像素总和校验，避免大量帧的gif耗尽内存
+func gifFrameCount(data []byte) (int, error) {
+	// 6字节的header以及7字节的logical screen descriptor
+	if len(data) < 13 {
+		return 0, errGIFInvalid
+	}
+	offset := 13
+	// 全局调色板
+	if flags := data[10]; flags&0x80 != 0 {
+		offset += 3 * (1 << (int(flags&0x07) + 1))
+	}
+	count := 0
+	var err error
+	for offset < len(data) {
+		switch data[offset] {
+		// 扩展块：类型后为数据子块
+		case 0x21:
+			offset, err = skipGIFSubBlocks(data, offset+2)
+			if err != nil {
+				return 0, err
+			}
+		// 图像：10字节的image descriptor、局部调色板、LZW的最小码长以及数据子块
+		case 0x2c:
+			if offset+10 > len(data) {
+				return 0, errGIFInvalid
+			}
+			flags := data[offset+9]
+			offset += 10
+			if flags&0x80 != 0 {
+				offset += 3 * (1 << (int(flags&0x07) + 1))
+			}
+			offset, err = skipGIFSubBlocks(data, offset+1)
+			if err != nil {
+				return 0, err
+			}
+			count++
+		// 结束
+		case 0x3b:
+			return count, nil
+		default:
+			return 0, errGIFInvalid
+		}
+	}
+	// 无结束标记时按已解析的帧数
+	return count, nil
+}
+
+func cloneNRGBA(img *image.NRGBA) *image.NRGBA {
+	result := image.NewNRGBA(img.Bounds())
+	copy(result.Pix, img.Pix)
+	return result
+}
+
+// decodeAnimation 解码gif的所有帧，并根据disposal合成完整画面。
+// 如果只有一帧则返回nil
+func decodeAnimation(data []byte) (*Animation, error) {
+	// 合成后每帧均为完整画面，因此解码前按所有帧的像素总和校验
+	_, _, err := CheckImageData(data)
+	if err != nil {
+		return nil, err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) <= 1 {
+		return nil, nil
+	}
+	// 帧的范围不可超出画面(解码时已校验)
+	canvas := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	anim := &Animation{
+		Frames:    make([]image.Image, len(g.Image)),
+		Delays:    make([]int, len(g.Image)),
+		Palettes:  make([]color.Palette, len(g.Image)),
+		LoopCount: g.LoopCount,
+	}
+	for index, frame := range g.Image {
+		var disposal byte
+		if index < len(g.Disposal) {
+			disposal = g.Disposal[index]
+		}
+		if index < len(g.Delay) {
+			anim.Delays[index] = g.Delay[index]
+		}
+		anim.Palettes[index] = frame.Palette
+
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneNRGBA(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.Frames[index] = cloneNRGBA(canvas)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return anim, nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/ent"
+)
+
+// StorageStatus storage的健康状态
+type StorageStatus struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Healthy  bool   `json:"healthy"`
+	// 检测耗时(ms)
+	Latency   int       `json:"latency"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// StorageTestResult storage的测试结果
+type StorageTestResult struct {
+	// 耗时(ms)
+	Latency int    `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// 记录所有storage最近一次的健康状态
+var storageStatuses = sync.Map{}
+
+// pingStorageClient 检测storage，返回耗时(ms)
+func pingStorageClient(ctx context.Context, client *storageClient) (int, error) {
+	startedAt := time.Now()
+	var err error
+	if client.ping != nil {
+		err = client.ping(ctx)
+	}
+	return int(time.Since(startedAt).Milliseconds()), err
+}
+
+// GetStorageStatus 获取storage最近一次的健康状态，未检测则返回nil
+func GetStorageStatus(name string) *StorageStatus {
+	value, ok := storageStatuses.Load(name)
+	if !ok {
+		return nil
+	}
+	status, _ := value.(*StorageStatus)
+	return status
+}
+
+// CheckStorages 检测所有已初始化的storage，
+// 返回所有的检测结果以及由正常转为异常(或首次检测即异常)的storage
+func CheckStorages(ctx context.Context, timeout time.Duration) ([]*StorageStatus, []*StorageStatus) {
+	clients := make(map[string]*storageClient)
+	storageClients.Range(func(key, value interface{}) bool {
+		name, _ := key.(string)
+		client, _ := value.(*storageClient)
+		if client != nil {
+			clients[name] = client
+		}
+		return true
+	})
+	statuses := make([]*StorageStatus, 0, len(clients))
+	downs := make([]*StorageStatus, 0)
+	for name, client := range clients {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		latency, err := pingStorageClient(pingCtx, client)
+		cancel()
+		status := &StorageStatus{
+			Name:      name,
+			Category:  client.category,
+			Healthy:   err == nil,
+			Latency:   latency,
+			CheckedAt: time.Now(),
+		}
+		if err != nil {
+			status.Error = hes.Wrap(err).Message
+		}
+		// 检测期间storage已被删除或重新初始化则不记录
+		if loadStorageClient(name) != client {
+			continue
+		}
+		prev := GetStorageStatus(name)
+		if !status.Healthy && (prev == nil || prev.Healthy) {
+			downs = append(downs, status)
+		}
+		storageStatuses.Store(name, status)
+		statuses = append(statuses, status)
+	}
+	return statuses, downs
+}
+
+// TestStorage 使用storage的配置新建连接检测是否可用，
+// 如果指定了参数则通过finder获取该图片，完成后关闭连接
+func TestStorage(ctx context.Context, item *ent.Storage, params ...string) *StorageTestResult {
+	startedAt := time.Now()
+	err := testStorage(ctx, item, params...)
+	result := &StorageTestResult{
+		Latency: int(time.Since(startedAt).Milliseconds()),
+	}
+	if err != nil {
+		result.Error = hes.Wrap(err).Message
+	}
+	return result
+}
+
+func testStorage(ctx context.Context, item *ent.Storage, params ...string) error {
+	client, err := newStorageClient(item.Name, item.Category.String(), resolveStorageURI(item.URI))
+	if err != nil {
+		return err
+	}
+	defer closeStorageClient(client)
+	_, err = pingStorageClient(ctx, client)
+	if err != nil {
+		return err
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	if client.finder == nil {
+		return hes.New("storage does not support finding image")
+	}
+	_, err = client.finder(ctx, params...)
+	return err
+}
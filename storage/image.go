@@ -15,20 +15,54 @@
 package storage
 
 import (
-	"bytes"
 	"context"
 	"image"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/vicanso/hes"
 	"github.com/vicanso/tiny-site/ent"
 )
 
+// imageVersionSeparator 图片名称与版本号的分隔符，如name@v3
+const imageVersionSeparator = "@v"
+
+var ErrImageVersionInvalid = hes.NewWithStatusCode("version of image is invalid", http.StatusBadRequest)
+
 type ImageFilterParams struct {
-	// 筛选的字段
+	// 筛选的字段，多个字段以,分隔，为空则为除图片数据外的所有字段
 	Fields string `json:"fields"`
+	// 是否返回图片数据
+	WithData bool `json:"withData"`
 	// 数量
 	Limit int `json:"limit"`
 	// 偏移量
 	Offset int `json:"offset"`
+	// 排序字段，如果以-前缀表示降序，多个字段以,分隔，默认为-id
+	Order string `json:"order"`
+
+	// bucket
+	Bucket string `json:"bucket"`
+	// 标签
+	Tag string `json:"tag"`
+	// 图片类型
+	Type string `json:"type"`
+	// 创建者
+	Creator string `json:"creator"`
+	// 数据长度的范围(字节)，为0表示不限制
+	MinSize int `json:"minSize"`
+	MaxSize int `json:"maxSize"`
+	// 宽度的范围，为0表示不限制
+	MinWidth int `json:"minWidth"`
+	MaxWidth int `json:"maxWidth"`
+	// 高度的范围，为0表示不限制
+	MinHeight int `json:"minHeight"`
+	MaxHeight int `json:"maxHeight"`
+	// 创建时间的范围，为零值表示不限制
+	CreatedAtFrom time.Time `json:"createdAtFrom"`
+	CreatedAtTo   time.Time `json:"createdAtTo"`
 }
 
 type ImageFinder func(ctx context.Context, params ...string) (*Image, error)
@@ -46,13 +80,22 @@ type Image struct {
 	Height int
 	// 图片数据
 	Data []byte
+	// 元数据(EXIF等)的处理策略，由图片所在的bucket设置，为空则保留
+	ExifPolicy string
 	// 图片数据转换的图像
 	img image.Image
+	// 图像已调整但数据未重新编码
+	changed bool
+	// 动图的所有帧(仅多帧的gif)
+	animation *Animation
+	// 是否已尝试解码动图
+	animationDecoded bool
 }
 
+// Image 获取图片数据转换的图像，已按EXIF的方向调整
 func (i *Image) Image() (image.Image, error) {
 	if i.img == nil {
-		img, _, err := image.Decode(bytes.NewReader(i.Data))
+		img, _, err := decodeOrientedImage(i.Data)
 		if err != nil {
 			return nil, err
 		}
@@ -62,7 +105,7 @@ func (i *Image) Image() (image.Image, error) {
 }
 
 func NewImageFromBytes(data []byte) (*Image, error) {
-	img, t, err := image.Decode(bytes.NewReader(data))
+	img, t, err := decodeOrientedImage(data)
 	if err != nil {
 		return nil, err
 	}
@@ -82,6 +125,49 @@ func (i *Image) SetData(data []byte) {
 	i.Data = data
 	i.Size = len(data)
 	i.img = nil
+	i.changed = false
+	i.animation = nil
+	i.animationDecoded = false
+}
+
+// SetImage 设置调整后的图像，数据在重新编码后才更新
+func (i *Image) SetImage(img image.Image) {
+	i.img = img
+	i.Width = img.Bounds().Dx()
+	i.Height = img.Bounds().Dy()
+	i.changed = true
+}
+
+// Animation 获取动图的所有帧，非动图返回nil
+func (i *Image) Animation() (*Animation, error) {
+	if i.animationDecoded || i.Type != "gif" {
+		return i.animation, nil
+	}
+	anim, err := decodeAnimation(i.Data)
+	if err != nil {
+		return nil, err
+	}
+	i.animation = anim
+	i.animationDecoded = true
+	return anim, nil
+}
+
+// SetAnimation 设置调整后的动图，图像为动图的第一帧
+func (i *Image) SetAnimation(anim *Animation) {
+	i.SetImage(anim.Frames[0])
+	i.animation = anim
+	i.animationDecoded = true
+}
+
+// ClearAnimation 清除动图，仅保留第一帧
+func (i *Image) ClearAnimation() {
+	i.animation = nil
+	i.animationDecoded = true
+}
+
+// Changed 图像是否已调整但数据未重新编码
+func (i *Image) Changed() bool {
+	return i.changed
 }
 
 type ImageStorage interface {
@@ -96,3 +182,17 @@ var entStorageClient = mustNewEntStorage()
 func Ent() ImageStorage {
 	return entStorageClient
 }
+
+// ParseImageVersion 解析图片名称中指定的版本(如name@v3)，
+// 返回图片名称与版本号，未指定版本则版本号为0
+func ParseImageVersion(name string) (string, int, error) {
+	index := strings.LastIndex(name, imageVersionSeparator)
+	if index < 0 {
+		return name, 0, nil
+	}
+	version, err := strconv.Atoi(name[index+len(imageVersionSeparator):])
+	if err != nil || version <= 0 || index == 0 {
+		return "", 0, ErrImageVersionInvalid
+	}
+	return name[:index], version, nil
+}
@@ -0,0 +1,296 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+
+	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/bucket"
+	"github.com/vicanso/tiny-site/ent/image"
+	"github.com/vicanso/tiny-site/ent/imageversion"
+	"github.com/vicanso/tiny-site/helper"
+	"github.com/vicanso/tiny-site/log"
+	"github.com/vicanso/tiny-site/util"
+)
+
+// ImageBlobRef 图片数据在storage中的位置
+type ImageBlobRef struct {
+	// storage的名称
+	Storage string
+	// storage中的bucket
+	Bucket string
+	// storage中的key
+	Key string
+}
+
+// getBucketBlobRef 获取bucket设置的storage，未设置则返回nil。
+// 每次保存均生成新的key，避免保存失败时覆盖原有的数据
+func getBucketBlobRef(ctx context.Context, bucketName, name string) (*ImageBlobRef, error) {
+	result, err := helper.EntGetClient().Bucket.Query().
+		Where(bucket.Name(bucketName)).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if result.Storage == "" {
+		return nil, nil
+	}
+	return NewImageBlobRef(result.Storage, result.StorageBucket, bucketName, name), nil
+}
+
+// NewImageBlobRef 生成图片数据在storage中的位置，storageBucket为空则使用bucket的名称
+func NewImageBlobRef(storageName, storageBucket, bucketName, name string) *ImageBlobRef {
+	if storageBucket == "" {
+		storageBucket = bucketName
+	}
+	return &ImageBlobRef{
+		Storage: storageName,
+		Bucket:  storageBucket,
+		Key:     bucketName + "/" + name + "/" + util.GenXID(),
+	}
+}
+
+// PutImageBlob 将图片数据保存至指定的位置
+func PutImageBlob(ctx context.Context, ref *ImageBlobRef, data []byte) error {
+	writer, err := GetWriter(ref.Storage)
+	if err != nil {
+		return err
+	}
+	return writer(ctx, ref.Bucket, ref.Key, data)
+}
+
+// SaveImageBlob 如果bucket设置了storage，则将图片数据保存至storage并返回其位置，
+// 未设置则返回nil，图片数据保存在数据库中
+func SaveImageBlob(ctx context.Context, bucketName, name string, data []byte) (*ImageBlobRef, error) {
+	ref, err := getBucketBlobRef(ctx, bucketName, name)
+	if err != nil || ref == nil {
+		return nil, err
+	}
+	err = PutImageBlob(ctx, ref, data)
+	if err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// GetImageBlobRef 获取图片数据的位置，保存在数据库中的返回nil
+func GetImageBlobRef(img *ent.Image) *ImageBlobRef {
+	if img.Storage == "" {
+		return nil
+	}
+	return &ImageBlobRef{
+		Storage: img.Storage,
+		Bucket:  img.StorageBucket,
+		Key:     img.StorageKey,
+	}
+}
+
+// GetImageData 获取图片数据，保存在storage中的从storage获取
+func GetImageData(ctx context.Context, img *ent.Image) ([]byte, error) {
+	ref := GetImageBlobRef(img)
+	if ref == nil {
+		return img.Data, nil
+	}
+	b, err := GetBlob(ref.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return b.Get(ctx, ref.Bucket, ref.Key)
+}
+
+// DeleteImageBlob 删除storage中的图片数据，失败时只输出日志，
+// 避免已更新的记录因删除旧数据失败而返回出错
+func DeleteImageBlob(ctx context.Context, ref *ImageBlobRef) {
+	if ref == nil {
+		return
+	}
+	deleter, err := GetDeleter(ref.Storage)
+	if err == nil {
+		err = deleter(ctx, ref.Bucket, ref.Key)
+	}
+	if err != nil {
+		log.Error(ctx).
+			Str("storage", ref.Storage).
+			Str("bucket", ref.Bucket).
+			Str("key", ref.Key).
+			Err(err).
+			Msg("delete image blob fail")
+	}
+}
+
+// SetImageBlob 设置图片数据，保存在storage中的则清除数据库中的数据
+func SetImageBlob(mutation *ent.ImageMutation, ref *ImageBlobRef, data []byte) {
+	mutation.SetSize(len(data))
+	if ref == nil {
+		mutation.SetData(data)
+		mutation.ClearStorage()
+		mutation.ClearStorageBucket()
+		mutation.ClearStorageKey()
+		return
+	}
+	mutation.ClearData()
+	mutation.SetStorage(ref.Storage)
+	mutation.SetStorageBucket(ref.Bucket)
+	mutation.SetStorageKey(ref.Key)
+}
+
+// MigrateImageBlobParams 迁移图片数据的参数
+type MigrateImageBlobParams struct {
+	// 仅迁移该bucket的图片，为空则迁移所有设置了storage的bucket
+	Bucket string
+	// 每批处理的数量
+	BatchSize int
+	// 仅统计需要迁移的数量
+	DryRun bool
+}
+
+// MigrateImageBlobResult 迁移图片数据的结果
+type MigrateImageBlobResult struct {
+	// 迁移的图片数量
+	Images int
+	// 迁移的历史版本数量
+	Versions int
+}
+
+// MigrateImageBlobs 将保存在数据库中的图片数据(包括历史版本)迁移至bucket设置的storage，
+// 返回迁移的数量
+func MigrateImageBlobs(ctx context.Context, params MigrateImageBlobParams) (*MigrateImageBlobResult, error) {
+	client := helper.EntGetClient()
+	result := &MigrateImageBlobResult{}
+	bucketQuery := client.Bucket.Query().
+		Where(bucket.StorageNEQ(""))
+	if params.Bucket != "" {
+		bucketQuery.Where(bucket.Name(params.Bucket))
+	}
+	buckets, err := bucketQuery.All(ctx)
+	if err != nil {
+		return result, err
+	}
+	if params.BatchSize <= 0 {
+		params.BatchSize = 100
+	}
+	for _, item := range buckets {
+		bucketName := item.Name
+		count, err := migrateBlobs(ctx, "migrate image blobs", bucketName, params, func(lastID int) ([]int, error) {
+			return client.Image.Query().
+				Where(
+					image.Bucket(bucketName),
+					image.Or(image.StorageIsNil(), image.StorageEQ("")),
+					image.IDGT(lastID),
+				).
+				Order(ent.Asc(image.FieldID)).
+				Limit(params.BatchSize).
+				IDs(ctx)
+		}, migrateImageBlob)
+		result.Images += count
+		if err != nil {
+			return result, err
+		}
+		count, err = migrateBlobs(ctx, "migrate image version blobs", bucketName, params, func(lastID int) ([]int, error) {
+			return client.ImageVersion.Query().
+				Where(
+					imageversion.Bucket(bucketName),
+					imageversion.Or(imageversion.StorageIsNil(), imageversion.StorageEQ("")),
+					imageversion.IDGT(lastID),
+				).
+				Order(ent.Asc(imageversion.FieldID)).
+				Limit(params.BatchSize).
+				IDs(ctx)
+		}, migrateImageVersionBlob)
+		result.Versions += count
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// migrateBlobs 分批获取需要迁移的记录id并逐条迁移，返回迁移的数量
+func migrateBlobs(ctx context.Context, desc, bucketName string, params MigrateImageBlobParams, queryIDs func(lastID int) ([]int, error), migrate func(ctx context.Context, id int) error) (int, error) {
+	count := 0
+	lastID := 0
+	for {
+		// 每次仅查询id，避免一次加载过多图片数据
+		ids, err := queryIDs(lastID)
+		if err != nil {
+			return count, err
+		}
+		if len(ids) == 0 {
+			return count, nil
+		}
+		lastID = ids[len(ids)-1]
+		if params.DryRun {
+			count += len(ids)
+			continue
+		}
+		for _, id := range ids {
+			err = migrate(ctx, id)
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+		log.Info(ctx).
+			Str("bucket", bucketName).
+			Int("count", count).
+			Msg(desc)
+	}
+}
+
+func migrateImageBlob(ctx context.Context, id int) error {
+	client := helper.EntGetClient()
+	img, err := client.Image.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	ref, err := SaveImageBlob(ctx, img.Bucket, img.Name, img.Data)
+	if err != nil || ref == nil {
+		return err
+	}
+	update := client.Image.UpdateOneID(id)
+	SetImageBlob(update.Mutation(), ref, img.Data)
+	_, err = update.Save(ctx)
+	if err != nil {
+		// 更新失败则删除已保存的数据
+		DeleteImageBlob(ctx, ref)
+		return err
+	}
+	return nil
+}
+
+func migrateImageVersionBlob(ctx context.Context, id int) error {
+	client := helper.EntGetClient()
+	version, err := client.ImageVersion.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	ref, err := SaveImageBlob(ctx, version.Bucket, version.Name, version.Data)
+	if err != nil || ref == nil {
+		return err
+	}
+	_, err = client.ImageVersion.UpdateOneID(id).
+		ClearData().
+		SetStorage(ref.Storage).
+		SetStorageBucket(ref.Bucket).
+		SetStorageKey(ref.Key).
+		Save(ctx)
+	if err != nil {
+		// 更新失败则删除已保存的数据
+		DeleteImageBlob(ctx, ref)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/bucket"
+	"github.com/vicanso/tiny-site/ent/image"
+	"github.com/vicanso/tiny-site/ent/imageversion"
+	"github.com/vicanso/tiny-site/helper"
+)
+
+func TestMigrateImageBlobs(t *testing.T) {
+	assert := assert.New(t)
+
+	storageName := "migrate-test"
+	b, err := newFileBlob("file://" + t.TempDir())
+	assert.Nil(err)
+	RegisterBlob(storageName, b)
+	defer UnregisterBlob(storageName)
+
+	ctx := context.Background()
+	// 先保存在数据库中，替换后历史版本的数据也保存在数据库中
+	bucketName := newTestBucket(t, "")
+	err = Ent().Put(ctx, ent.Image{
+		Bucket:   bucketName,
+		Name:     "migrate",
+		Type:     "png",
+		Width:    1,
+		Height:   1,
+		Creator:  "test",
+		Data:     []byte("v1"),
+		Metadata: &http.Header{},
+	})
+	assert.Nil(err)
+	_, err = ReplaceImage(ctx, bucketName, "migrate", nil, ent.Image{
+		Type:     "png",
+		Width:    1,
+		Height:   1,
+		Creator:  "test",
+		Data:     []byte("v2"),
+		Metadata: &http.Header{},
+	})
+	assert.Nil(err)
+
+	client := helper.EntGetClient()
+	err = client.Bucket.Update().
+		Where(bucket.Name(bucketName)).
+		SetStorage(storageName).
+		Exec(ctx)
+	assert.Nil(err)
+
+	params := MigrateImageBlobParams{
+		Bucket: bucketName,
+		DryRun: true,
+	}
+	result, err := MigrateImageBlobs(ctx, params)
+	assert.Nil(err)
+	assert.Equal(&MigrateImageBlobResult{
+		Images:   1,
+		Versions: 1,
+	}, result)
+
+	params.DryRun = false
+	result, err = MigrateImageBlobs(ctx, params)
+	assert.Nil(err)
+	assert.Equal(&MigrateImageBlobResult{
+		Images:   1,
+		Versions: 1,
+	}, result)
+
+	// 数据库中不再保存图片数据
+	img, err := client.Image.Query().
+		Where(image.Bucket(bucketName), image.Name("migrate")).
+		First(ctx)
+	assert.Nil(err)
+	assert.Equal(storageName, img.Storage)
+	assert.Empty(img.Data)
+	version, err := client.ImageVersion.Query().
+		Where(imageversion.Bucket(bucketName), imageversion.Name("migrate")).
+		First(ctx)
+	assert.Nil(err)
+	assert.Equal(storageName, version.Storage)
+	assert.Empty(version.Data)
+
+	// 从storage中获取的数据与迁移前一致
+	current, err := Ent().Get(ctx, bucketName, "migrate")
+	assert.Nil(err)
+	assert.Equal([]byte("v2"), current.Data)
+	prev, err := GetImageVersion(ctx, bucketName, "migrate", 1)
+	assert.Nil(err)
+	assert.Equal([]byte("v1"), prev.Data)
+
+	// 已迁移的不再重复迁移
+	result, err = MigrateImageBlobs(ctx, params)
+	assert.Nil(err)
+	assert.Equal(&MigrateImageBlobResult{}, result)
+}
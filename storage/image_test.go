@@ -0,0 +1,46 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseImageVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	name, version, err := ParseImageVersion("abc")
+	assert.Nil(err)
+	assert.Equal("abc", name)
+	assert.Equal(0, version)
+
+	name, version, err = ParseImageVersion("abc@v3")
+	assert.Nil(err)
+	assert.Equal("abc", name)
+	assert.Equal(3, version)
+
+	for _, value := range []string{
+		"abc@v",
+		"abc@v0",
+		"abc@v-1",
+		"abc@vx",
+		"@v1",
+	} {
+		_, _, err = ParseImageVersion(value)
+		assert.Equal(ErrImageVersionInvalid, err, value)
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/bucket"
+	"github.com/vicanso/tiny-site/ent/image"
+	"github.com/vicanso/tiny-site/ent/imageversion"
+	"github.com/vicanso/tiny-site/helper"
+	"github.com/vicanso/tiny-site/log"
+)
+
+var (
+	ErrImageNotFound        = hes.NewWithStatusCode("image is not found", http.StatusNotFound)
+	ErrImageVersionNotFound = hes.NewWithStatusCode("version of image is not found", http.StatusNotFound)
+	ErrImageVersionConflict = hes.NewWithStatusCode("image is replaced by others, please retry", http.StatusConflict)
+)
+
+// versionToImage 历史版本转换为图片，用于获取其数据
+func versionToImage(item *ent.ImageVersion) *ent.Image {
+	return &ent.Image{
+		CreatedAt:     item.CreatedAt,
+		UpdatedAt:     item.UpdatedAt,
+		Bucket:        item.Bucket,
+		Name:          item.Name,
+		Version:       item.Version,
+		Type:          item.Type,
+		Size:          item.Size,
+		Width:         item.Width,
+		Height:        item.Height,
+		Metadata:      item.Metadata,
+		Creator:       item.Creator,
+		Data:          item.Data,
+		Storage:       item.Storage,
+		StorageBucket: item.StorageBucket,
+		StorageKey:    item.StorageKey,
+	}
+}
+
+// setImageFields 设置非空的字段
+func setImageFields(mutation *ent.ImageMutation, data ent.Image) {
+	if data.Bucket != "" {
+		mutation.SetBucket(data.Bucket)
+	}
+	if data.Name != "" {
+		mutation.SetName(data.Name)
+	}
+	if data.Type != "" {
+		mutation.SetType(data.Type)
+	}
+	if data.Width != 0 {
+		mutation.SetWidth(data.Width)
+	}
+	if data.Height != 0 {
+		mutation.SetHeight(data.Height)
+	}
+	if data.Metadata != nil {
+		mutation.SetMetadata(data.Metadata)
+	}
+	if len(data.Tags) != 0 {
+		mutation.SetTags(data.Tags)
+	}
+	if data.Creator != "" {
+		mutation.SetCreator(data.Creator)
+	}
+	if data.Description != "" {
+		mutation.SetDescription(data.Description)
+	}
+}
+
+// GetImageVersion 获取图片指定版本的历史记录(包括图片数据)
+func GetImageVersion(ctx context.Context, bucketName, name string, version int) (*ent.Image, error) {
+	result, err := helper.EntGetClient().ImageVersion.Query().
+		Where(
+			imageversion.Bucket(bucketName),
+			imageversion.Name(name),
+			imageversion.Version(version),
+		).
+		First(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return nil, ErrImageVersionNotFound
+		}
+		return nil, err
+	}
+	img := versionToImage(result)
+	img.Data, err = GetImageData(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// ListImageVersions 获取图片的历史版本(不包括图片数据)，按版本号降序
+func ListImageVersions(ctx context.Context, bucketName, name string) ([]*ent.ImageVersion, error) {
+	fields := make([]string, 0, len(imageversion.Columns))
+	for _, column := range imageversion.Columns {
+		if column != imageversion.FieldData {
+			fields = append(fields, column)
+		}
+	}
+	result := make([]*ent.ImageVersion, 0)
+	err := helper.EntGetClient().ImageVersion.Query().
+		Where(
+			imageversion.Bucket(bucketName),
+			imageversion.Name(name),
+		).
+		Order(ent.Desc(imageversion.FieldVersion)).
+		Select(fields...).
+		Scan(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// IsStorageUsed 判断是否有图片或历史版本的数据保存在该storage
+func IsStorageUsed(ctx context.Context, name string) (bool, error) {
+	client := helper.EntGetClient()
+	used, err := client.Image.Query().
+		Where(image.Storage(name)).
+		Exist(ctx)
+	if err != nil || used {
+		return used, err
+	}
+	return client.ImageVersion.Query().
+		Where(imageversion.Storage(name)).
+		Exist(ctx)
+}
+
+// ReplaceImage 替换图片的数据，原有的数据保存为历史版本，版本号加1。
+// ref为已保存的新数据的位置(保存在数据库中的为nil)，替换失败时删除
+func ReplaceImage(ctx context.Context, bucketName, name string, ref *ImageBlobRef, data ent.Image) (*ent.Image, error) {
+	current, err := helper.EntGetClient().Image.Query().
+		Where(
+			image.Bucket(bucketName),
+			image.Name(name),
+			image.DeletedAtIsNil(),
+		).
+		First(ctx)
+	if err != nil {
+		DeleteImageBlob(ctx, ref)
+		if ent.IsNotFound(err) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	return replaceImage(ctx, current, ref, data)
+}
+
+// RollbackImage 回滚图片至指定版本，以该版本的数据生成新的版本，
+// 因此回滚前的数据也保存为历史版本
+func RollbackImage(ctx context.Context, bucketName, name string, version int, operator string) (*ent.Image, error) {
+	img, err := GetImageVersion(ctx, bucketName, name, version)
+	if err != nil {
+		return nil, err
+	}
+	// 历史版本可能被删除，因此数据需重新保存
+	ref, err := SaveImageBlob(ctx, bucketName, name, img.Data)
+	if err != nil {
+		return nil, err
+	}
+	// metadata由数据提取，需与数据一同恢复，
+	// 未保存metadata的历史版本则清除，避免保留替换前图片的metadata
+	metadata := img.Metadata
+	if metadata == nil {
+		metadata = &http.Header{}
+	}
+	return ReplaceImage(ctx, bucketName, name, ref, ent.Image{
+		Type:     img.Type,
+		Width:    img.Width,
+		Height:   img.Height,
+		Metadata: metadata,
+		Creator:  operator,
+		Data:     img.Data,
+	})
+}
+
+func replaceImage(ctx context.Context, current *ent.Image, ref *ImageBlobRef, data ent.Image) (*ent.Image, error) {
+	result, err := doReplaceImage(ctx, current, ref, data)
+	if err != nil {
+		DeleteImageBlob(ctx, ref)
+		return nil, err
+	}
+	err = pruneImageVersions(ctx, result.Bucket, result.Name)
+	if err != nil {
+		log.Error(ctx).
+			Str("bucket", result.Bucket).
+			Str("name", result.Name).
+			Err(err).
+			Msg("prune image versions fail")
+	}
+	return result, nil
+}
+
+func doReplaceImage(ctx context.Context, current *ent.Image, ref *ImageBlobRef, data ent.Image) (*ent.Image, error) {
+	tx, err := helper.EntGetClient().Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 原有数据的位置转移至历史版本，因此无需复制
+	_, err = tx.ImageVersion.Create().
+		SetBucket(current.Bucket).
+		SetName(current.Name).
+		SetVersion(current.Version).
+		SetType(current.Type).
+		SetSize(current.Size).
+		SetWidth(current.Width).
+		SetHeight(current.Height).
+		SetMetadata(current.Metadata).
+		SetCreator(current.Creator).
+		SetData(current.Data).
+		SetStorage(current.Storage).
+		SetStorageBucket(current.StorageBucket).
+		SetStorageKey(current.StorageKey).
+		Save(ctx)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	// 以版本号判断是否已被其它请求替换
+	update := tx.Image.Update().
+		Where(
+			image.ID(current.ID),
+			image.Version(current.Version),
+		).
+		SetVersion(current.Version + 1)
+	setImageFields(update.Mutation(), data)
+	SetImageBlob(update.Mutation(), ref, data.Data)
+	count, err := update.Save(ctx)
+	if err == nil && count == 0 {
+		err = ErrImageVersionConflict
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return helper.EntGetClient().Image.Get(ctx, current.ID)
+}
+
+// pruneImageVersions 删除超出bucket设置数量的历史版本
+func pruneImageVersions(ctx context.Context, bucketName, name string) error {
+	client := helper.EntGetClient()
+	result, err := client.Bucket.Query().
+		Where(bucket.Name(bucketName)).
+		First(ctx)
+	if err != nil {
+		return err
+	}
+	if result.MaxVersions <= 0 {
+		return nil
+	}
+	return deleteImageVersions(ctx, "prune image versions", bucketName, name, result.MaxVersions)
+}
+
+// deleteImageVersions 删除按版本号降序偏移offset之后的历史版本及其在storage中的数据
+func deleteImageVersions(ctx context.Context, reason, bucketName, name string, offset int) error {
+	client := helper.EntGetClient()
+	ctx = helper.EntAllowDelete(ctx, reason)
+	for {
+		versions := make([]*ent.ImageVersion, 0)
+		err := client.ImageVersion.Query().
+			Where(
+				imageversion.Bucket(bucketName),
+				imageversion.Name(name),
+			).
+			Order(ent.Desc(imageversion.FieldVersion)).
+			Offset(offset).
+			Limit(100).
+			Select(
+				imageversion.FieldID,
+				imageversion.FieldStorage,
+				imageversion.FieldStorageBucket,
+				imageversion.FieldStorageKey,
+			).
+			Scan(ctx, &versions)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return nil
+		}
+		for _, item := range versions {
+			err = client.ImageVersion.DeleteOneID(item.ID).Exec(ctx)
+			if err != nil && !ent.IsNotFound(err) {
+				return err
+			}
+			DeleteImageBlob(ctx, GetImageBlobRef(versionToImage(item)))
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/bucket"
+	"github.com/vicanso/tiny-site/ent/image"
+	"github.com/vicanso/tiny-site/ent/imageversion"
+	"github.com/vicanso/tiny-site/helper"
+)
+
+// newTestBucket 创建测试的bucket，storageName为空则图片数据保存在数据库中，
+// 测试结束后删除该bucket及其图片与历史版本
+func newTestBucket(t *testing.T, storageName string) string {
+	assert := assert.New(t)
+	assert.Nil(helper.EntInitSchema())
+
+	client := helper.EntGetClient()
+	name := "test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	_, err := client.Bucket.Create().
+		SetName(name).
+		SetCreator("test").
+		SetDescription("test bucket").
+		SetStorage(storageName).
+		Save(context.Background())
+	assert.Nil(err)
+	t.Cleanup(func() {
+		ctx := helper.EntAllowDelete(context.Background(), "clean test bucket")
+		_, _ = client.Image.Delete().Where(image.Bucket(name)).Exec(ctx)
+		_, _ = client.ImageVersion.Delete().Where(imageversion.Bucket(name)).Exec(ctx)
+		_, _ = client.Bucket.Delete().Where(bucket.Name(name)).Exec(ctx)
+	})
+	return name
+}
+
+func TestRollbackImage(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	bucketName := newTestBucket(t, "")
+	err := Ent().Put(ctx, ent.Image{
+		Bucket:  bucketName,
+		Name:    "rollback",
+		Type:    "png",
+		Width:   1,
+		Height:  1,
+		Creator: "test",
+		Data:    newFakePNG(1, 1),
+		Metadata: &http.Header{
+			"Blurhash": []string{"v1"},
+		},
+	})
+	assert.Nil(err)
+
+	// 替换后的图片使用新的metadata
+	replaced, err := ReplaceImage(ctx, bucketName, "rollback", nil, ent.Image{
+		Type:    "png",
+		Width:   2,
+		Height:  1,
+		Creator: "test",
+		Data:    newFakePNG(2, 1),
+		Metadata: &http.Header{
+			"Blurhash":     []string{"v2"},
+			"Gps-Latitude": []string{"22.500000"},
+		},
+	})
+	assert.Nil(err)
+	assert.Equal(2, replaced.Version)
+	assert.Equal("v2", replaced.Metadata.Get("Blurhash"))
+
+	// 回滚后metadata与数据一同恢复
+	result, err := RollbackImage(ctx, bucketName, "rollback", 1, "test")
+	assert.Nil(err)
+	assert.Equal(3, result.Version)
+	assert.Equal(1, result.Width)
+	assert.Equal("v1", result.Metadata.Get("Blurhash"))
+	assert.Empty(result.Metadata.Get("Gps-Latitude"))
+
+	// 历史版本保存了替换前的metadata
+	img, err := GetImageVersion(ctx, bucketName, "rollback", 2)
+	assert.Nil(err)
+	assert.Equal("v2", img.Metadata.Get("Blurhash"))
+}
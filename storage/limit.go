@@ -0,0 +1,105 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+
+	"github.com/vicanso/hes"
+	"github.com/vicanso/tiny-site/config"
+)
+
+const errImageLimitCategory = "image-limit"
+
+var imageLimitConfig = config.MustGetImageLimitConfig()
+
+func newImageLimitError(message string) *hes.Error {
+	return hes.NewWithStatusCode(message, http.StatusRequestEntityTooLarge, errImageLimitCategory)
+}
+
+// checkImageSize 校验图片的数据长度
+func checkImageSize(size int) error {
+	if size > imageLimitConfig.MaxSize {
+		return newImageLimitError(fmt.Sprintf("size of image should be <= %d bytes", imageLimitConfig.MaxSize))
+	}
+	return nil
+}
+
+// checkImageDimension 校验图片的宽高以及像素数量，frames为动图的帧数
+func checkImageDimension(width, height, frames int) error {
+	if width > imageLimitConfig.MaxWidth || height > imageLimitConfig.MaxHeight {
+		return newImageLimitError(fmt.Sprintf("dimension of image(%dx%d) should be <= %dx%d", width, height, imageLimitConfig.MaxWidth, imageLimitConfig.MaxHeight))
+	}
+	// 分开判断，避免相乘时溢出
+	pixels := width * height
+	if pixels > imageLimitConfig.MaxPixels || frames > imageLimitConfig.MaxPixels/pixels {
+		return newImageLimitError(fmt.Sprintf("pixels of image should be <= %d", imageLimitConfig.MaxPixels))
+	}
+	return nil
+}
+
+// CheckImageData 在解码前通过图片头信息校验数据长度、宽高以及像素数量
+func CheckImageData(data []byte) (image.Config, string, error) {
+	err := checkImageSize(len(data))
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return image.Config{}, "", hes.New("dimension of image is invalid")
+	}
+	// gif的每帧均需解码，按所有帧的像素总和校验
+	frames := 1
+	if format == "gif" {
+		frames, err = gifFrameCount(data)
+		if err != nil {
+			return image.Config{}, "", err
+		}
+	}
+	err = checkImageDimension(cfg.Width, cfg.Height, frames)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	return cfg, format, nil
+}
+
+// DecodeImage 校验通过后再解码图片
+func DecodeImage(data []byte) (image.Image, string, error) {
+	_, _, err := CheckImageData(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// ReadImageData 读取图片数据，超过最大长度则返回出错
+func ReadImageData(r io.Reader) ([]byte, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, int64(imageLimitConfig.MaxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	err = checkImageSize(len(buf))
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/hes"
+)
+
+// newFakePNG 生成一个png，并修改其头信息中的宽高
+func newFakePNG(width, height uint32) []byte {
+	buffer := bytes.Buffer{}
+	_ = png.Encode(&buffer, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := buffer.Bytes()
+	// 8字节的签名，4字节长度，4字节类型，之后为IHDR的数据
+	ihdr := data[16:29]
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestCheckImageData(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg, format, err := CheckImageData(newFakePNG(100, 50))
+	assert.Nil(err)
+	assert.Equal("png", format)
+	assert.Equal(100, cfg.Width)
+	assert.Equal(50, cfg.Height)
+
+	// 宽高超出限制
+	_, _, err = CheckImageData(newFakePNG(50000, 50000))
+	he := hes.Wrap(err)
+	assert.Equal(http.StatusRequestEntityTooLarge, he.StatusCode)
+	assert.True(strings.HasPrefix(he.Message, "dimension of image"))
+
+	// 像素数量超出限制
+	_, _, err = CheckImageData(newFakePNG(
+		uint32(imageLimitConfig.MaxWidth),
+		uint32(imageLimitConfig.MaxHeight),
+	))
+	assert.True(strings.HasPrefix(hes.Wrap(err).Message, "pixels of image"))
+
+	// 超大图片不会被解码
+	_, err = NewImageFromBytes(newFakePNG(50000, 50000))
+	assert.NotNil(err)
+
+	// 数据长度超出限制
+	_, _, err = CheckImageData(make([]byte, imageLimitConfig.MaxSize+1))
+	assert.True(strings.HasPrefix(hes.Wrap(err).Message, "size of image"))
+}
+
+func TestCheckImageDimension(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(checkImageDimension(100, 100, 1))
+	assert.Nil(checkImageDimension(100, 100, 100))
+	// 动图的所有帧总和超出限制
+	assert.NotNil(checkImageDimension(1000, 1000, imageLimitConfig.MaxPixels/1000000+1))
+}
+
+// newTestGIF 生成画面为width*height，包含frames个1x1帧的gif
+func newTestGIF(width, height, frames int) []byte {
+	g := &gif.GIF{
+		Config: image.Config{
+			Width:      width,
+			Height:     height,
+			ColorModel: color.Palette(palette.Plan9),
+		},
+	}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9))
+		g.Delay = append(g.Delay, 0)
+	}
+	buffer := bytes.Buffer{}
+	_ = gif.EncodeAll(&buffer, g)
+	return buffer.Bytes()
+}
+
+func TestGIFFrameCount(t *testing.T) {
+	assert := assert.New(t)
+
+	count, err := gifFrameCount(newTestGIF(10, 10, 3))
+	assert.Nil(err)
+	assert.Equal(3, count)
+
+	_, err = gifFrameCount([]byte("GIF89a"))
+	assert.NotNil(err)
+
+	// 帧数较多的gif在解码前按所有帧的像素总和校验
+	frames := imageLimitConfig.MaxPixels/(1000*1000) + 1
+	data := newTestGIF(1000, 1000, frames)
+	_, _, err = CheckImageData(data)
+	assert.True(strings.HasPrefix(hes.Wrap(err).Message, "pixels of image"))
+	_, err = decodeAnimation(data)
+	assert.NotNil(err)
+
+	_, _, err = CheckImageData(newTestGIF(1000, 1000, frames-1))
+	assert.Nil(err)
+}
+
+func TestReadImageData(t *testing.T) {
+	assert := assert.New(t)
+
+	buf, err := ReadImageData(bytes.NewReader([]byte("abc")))
+	assert.Nil(err)
+	assert.Equal([]byte("abc"), buf)
+
+	_, err = ReadImageData(bytes.NewReader(make([]byte, imageLimitConfig.MaxSize+1)))
+	assert.NotNil(err)
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/vicanso/hes"
+)
+
+// newS3Options 根据连接串生成s3(minio、aws s3、r2等)的参数，格式如下：
+// s3://host:port/?accessKey=xxx&secretKey=xxx&sessionToken=xxx&region=xxx&secure=true&pathStyle=true&ca=/path/ca.pem
+// secure未指定时使用defaultSecure，pathStyle未指定时根据endpoint自动判断，
+// ca为自定义的CA证书(PEM)，会添加至系统证书中
+func newS3Options(uri string, defaultSecure bool) (string, *minio.Options, *http.Transport, error) {
+	urlInfo, err := url.Parse(uri)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if urlInfo.Host == "" {
+		return "", nil, nil, hes.New("endpoint of s3 can not be empty")
+	}
+	query := urlInfo.Query()
+	secure := defaultSecure
+	if value := query.Get("secure"); value != "" {
+		secure, err = strconv.ParseBool(value)
+		if err != nil {
+			return "", nil, nil, hes.New("secure of s3 is invalid")
+		}
+	}
+	bucketLookup := minio.BucketLookupAuto
+	if value := query.Get("pathStyle"); value != "" {
+		pathStyle, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", nil, nil, hes.New("path style of s3 is invalid")
+		}
+		bucketLookup = minio.BucketLookupDNS
+		if pathStyle {
+			bucketLookup = minio.BucketLookupPath
+		}
+	}
+
+	// 使用单独的transport，关闭时释放其连接
+	transport, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if ca := query.Get("ca"); ca != "" {
+		if !secure {
+			return "", nil, nil, hes.New("ca of s3 should be used with secure")
+		}
+		pool, err := newCertPool(ca)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	accessKey := query.Get("accessKey")
+	secretKey := query.Get("secretKey")
+	return urlInfo.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(accessKey, secretKey, query.Get("sessionToken")),
+		Secure:       secure,
+		Transport:    transport,
+		Region:       query.Get("region"),
+		BucketLookup: bucketLookup,
+	}, transport, nil
+}
+
+// newCertPool 在系统证书的基础上添加自定义的CA证书
+func newCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, hes.New("ca of s3 is invalid")
+	}
+	return pool, nil
+}
+
+// newS3Client 根据连接串创建s3的client，返回client以及其使用的transport
+func newS3Client(uri string, defaultSecure bool) (*minio.Client, *http.Transport, error) {
+	endpoint, opts, transport, err := newS3Options(uri, defaultSecure)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := minio.New(endpoint, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, transport, nil
+}
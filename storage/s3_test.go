@@ -0,0 +1,294 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/pem"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/schema"
+)
+
+// s3StandIn 用于测试的s3服务(代替minio)，仅支持对象的读写删除
+type s3StandIn struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	// 最近一次请求的host、path与header
+	host   string
+	path   string
+	header http.Header
+}
+
+func newS3StandIn() *s3StandIn {
+	return &s3StandIn{
+		objects: make(map[string][]byte),
+	}
+}
+
+// readStreamingBody 读取aws-chunked格式的数据
+func readStreamingBody(r io.Reader) ([]byte, error) {
+	reader := bufio.NewReader(r)
+	buf := &bytes.Buffer{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return buf.Bytes(), nil
+		}
+		_, err = io.CopyN(buf, reader, size)
+		if err != nil {
+			return nil, err
+		}
+		// 跳过\r\n
+		_, err = reader.Discard(2)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.host = req.Host
+	s.path = req.URL.Path
+	s.header = req.Header.Clone()
+
+	name := strings.TrimPrefix(req.URL.Path, "/")
+	// virtual host的形式bucket在host中
+	if host, _, _ := net.SplitHostPort(req.Host); strings.HasSuffix(host, ".localhost") {
+		name = strings.TrimSuffix(host, ".localhost") + "/" + name
+	}
+	_, location := req.URL.Query()["location"]
+	switch {
+	case location:
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<LocationConstraint>us-east-1</LocationConstraint>`))
+	case name == "" && req.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<ListAllMyBucketsResult><Buckets><Bucket><Name>test</Name><CreationDate>2022-01-01T00:00:00.000Z</CreationDate></Bucket></Buckets></ListAllMyBucketsResult>`))
+	case req.Method == http.MethodPut:
+		var data []byte
+		var err error
+		if req.Header.Get("X-Amz-Content-Sha256") == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+			data, err = readStreamingBody(req.Body)
+		} else {
+			data, err = io.ReadAll(req.Body)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[name] = data
+		w.Header().Set("ETag", `"etag"`)
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		data, ok := s.objects[name]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case req.Method == http.MethodDelete:
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestPNG(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNewS3Options(t *testing.T) {
+	assert := assert.New(t)
+
+	_, opts, _, err := newS3Options("s3://127.0.0.1:9000/?accessKey=a&secretKey=b", true)
+	assert.Nil(err)
+	assert.True(opts.Secure)
+	assert.Equal(minio.BucketLookupAuto, opts.BucketLookup)
+
+	endpoint, opts, _, err := newS3Options("s3://s3.amazonaws.com/?secure=false&region=us-east-1&pathStyle=false", true)
+	assert.Nil(err)
+	assert.Equal("s3.amazonaws.com", endpoint)
+	assert.False(opts.Secure)
+	assert.Equal("us-east-1", opts.Region)
+	assert.Equal(minio.BucketLookupDNS, opts.BucketLookup)
+
+	_, opts, _, err = newS3Options("minio://127.0.0.1:9000/?pathStyle=true", false)
+	assert.Nil(err)
+	assert.False(opts.Secure)
+	assert.Equal(minio.BucketLookupPath, opts.BucketLookup)
+
+	for _, uri := range []string{
+		"s3:///?accessKey=a",
+		"s3://127.0.0.1/?secure=abc",
+		"s3://127.0.0.1/?pathStyle=abc",
+		"s3://127.0.0.1/?ca=/not-exists.pem",
+		"s3://127.0.0.1/?secure=false&ca=/not-exists.pem",
+	} {
+		_, _, _, err = newS3Options(uri, true)
+		assert.NotNil(err, uri)
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	assert := assert.New(t)
+	standIn := newS3StandIn()
+	server := httptest.NewUnstartedServer(standIn)
+	// 忽略证书校验失败的日志
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	// 将测试服务的证书作为自定义CA
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0600)
+	assert.Nil(err)
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	ctx := context.Background()
+
+	// 未指定CA时校验证书失败
+	client, err := newStorageClient("s3-test", schema.StorageCategoryS3, "s3://"+host+"/?accessKey=a&secretKey=b&region=cn-south")
+	assert.Nil(err)
+	pingCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	assert.NotNil(client.ping(pingCtx))
+	closeStorageClient(client)
+
+	client, err = newStorageClient("s3-test", schema.StorageCategoryS3, "s3://"+host+"/?accessKey=a&secretKey=b&sessionToken=token&region=cn-south&pathStyle=true&ca="+ca)
+	assert.Nil(err)
+	defer closeStorageClient(client)
+	assert.Nil(client.ping(ctx))
+
+	data := newTestPNG(t)
+	err = client.blob.Put(ctx, "test", "a/b.png", data)
+	assert.Nil(err)
+	assert.Equal("/test/a/b.png", standIn.path)
+	assert.Equal("token", standIn.header.Get("X-Amz-Security-Token"))
+	assert.Contains(standIn.header.Get("Authorization"), "/cn-south/s3/aws4_request")
+	// https不使用streaming的签名
+	assert.NotEqual("STREAMING-AWS4-HMAC-SHA256-PAYLOAD", standIn.header.Get("X-Amz-Content-Sha256"))
+
+	buf, err := client.blob.Get(ctx, "test", "a/b.png")
+	assert.Nil(err)
+	assert.Equal(data, buf)
+
+	img, err := client.finder(ctx, "test", "a/b.png")
+	assert.Nil(err)
+	assert.Equal(10, img.Width)
+
+	err = client.blob.Delete(ctx, "test", "a/b.png")
+	assert.Nil(err)
+	_, err = client.blob.Get(ctx, "test", "a/b.png")
+	assert.NotNil(err)
+}
+
+func TestS3VirtualHost(t *testing.T) {
+	assert := assert.New(t)
+	standIn := newS3StandIn()
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	assert.Nil(err)
+	endpoint, opts, transport, err := newS3Options("s3://localhost:"+port+"/?accessKey=a&secretKey=b&region=us-east-1&pathStyle=false&secure=false", true)
+	assert.Nil(err)
+	// bucket.localhost均连接至测试服务
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	client, err := minio.New(endpoint, opts)
+	assert.Nil(err)
+
+	b := &minioBlob{
+		client: client,
+	}
+	data := newTestPNG(t)
+	ctx := context.Background()
+	err = b.Put(ctx, "test", "a.png", data)
+	assert.Nil(err)
+	assert.Equal("test.localhost:"+port, standIn.host)
+	assert.Equal("/a.png", standIn.path)
+
+	buf, err := b.Get(ctx, "test", "a.png")
+	assert.Nil(err)
+	assert.Equal(data, buf)
+}
+
+func TestMinioStorage(t *testing.T) {
+	assert := assert.New(t)
+	standIn := newS3StandIn()
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	client, err := newStorageClient("minio-test", schema.StorageCategoryMinio, "minio://"+host+"/?accessKey=a&secretKey=b")
+	assert.Nil(err)
+	defer closeStorageClient(client)
+
+	ctx := context.Background()
+	assert.Nil(client.ping(ctx))
+	data := newTestPNG(t)
+	err = client.blob.Put(ctx, "test", "a.png", data)
+	assert.Nil(err)
+	// minio默认使用http，写入时使用streaming的签名
+	assert.Equal("STREAMING-AWS4-HMAC-SHA256-PAYLOAD", standIn.header.Get("X-Amz-Content-Sha256"))
+
+	buf, err := client.blob.Get(ctx, "test", "a.png")
+	assert.Nil(err)
+	assert.Equal(data, buf)
+}
@@ -15,9 +15,8 @@
 package storage
 
 import (
-	"bytes"
 	"context"
-	"io"
+	"net"
 	"net/url"
 	"os"
 	"strings"
@@ -25,10 +24,9 @@ import (
 	"time"
 
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/vicanso/go-axios"
 	"github.com/vicanso/hes"
-	"github.com/vicanso/tiny-site/ent/storage"
+	"github.com/vicanso/tiny-site/ent"
 	"github.com/vicanso/tiny-site/helper"
 	"github.com/vicanso/tiny-site/log"
 	"github.com/vicanso/tiny-site/schema"
@@ -42,15 +40,40 @@ import (
 	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 )
 
-// 记录所有的http upstream
-var httpUpstreams = sync.Map{}
+var finders = sync.Map{}
 
-// 记录所有的mongodb client
-var mongoClients = sync.Map{}
+// storageClient storage初始化后的finder与blob
+type storageClient struct {
+	// 配置的标识，配置有变化时才重新初始化
+	version  string
+	category string
+	finder   ImageFinder
+	blob     Blob
+	// 检测是否可用
+	ping func(ctx context.Context) error
+	// 关闭连接、停止health check等
+	close func()
+}
 
-var finders = sync.Map{}
+// 记录所有已初始化的storage
+var storageClients = sync.Map{}
 
-func newHTTPImageFinder(name, uri string) (ImageFinder, error) {
+// 更新storage时加锁，避免同时更新（连接时不加锁）
+var storageMutex = sync.Mutex{}
+
+// 每次更新storage时递增的序号，记录各storage最近一次更新的序号，
+// 连接完成时若已有更新的操作则丢弃此次的连接
+var storageSeq int64
+var storageLatestSeqs = map[string]int64{}
+
+// nextStorageSeq 生成storage更新的序号，需在加锁后调用
+func nextStorageSeq(name string) int64 {
+	storageSeq++
+	storageLatestSeqs[name] = storageSeq
+	return storageSeq
+}
+
+func newHTTPStorage(uri string) (*storageClient, error) {
 	urlInfo, err := url.Parse(uri)
 	if err != nil {
 		return nil, err
@@ -58,10 +81,18 @@ func newHTTPImageFinder(name, uri string) (ImageFinder, error) {
 	uh := &upstream.HTTP{
 		Ping: urlInfo.Path,
 	}
+	hostnames := make([]string, 0)
 	for _, host := range strings.Split(urlInfo.Host, ",") {
 		uh.Add(urlInfo.Scheme + "://" + host)
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		hostnames = append(hostnames, strings.Trim(host, "[]"))
+	}
+	ins, err := newStorageHTTPInstance(hostnames)
+	if err != nil {
+		return nil, err
 	}
-	httpUpstreams.Store(name, uh)
 	uh.OnStatus(func(status int32, upstream *upstream.HTTPUpstream) {
 		log.Info(context.Background()).
 			Str("addr", upstream.URL.String()).
@@ -71,7 +102,7 @@ func newHTTPImageFinder(name, uri string) (ImageFinder, error) {
 	// 先执行一次health check
 	uh.DoHealthCheck()
 	go uh.StartHealthCheck()
-	return func(ctx context.Context, params ...string) (*Image, error) {
+	finder := func(ctx context.Context, params ...string) (*Image, error) {
 		if len(params) == 0 {
 			return nil, hes.New("request uri can not be empty")
 		}
@@ -83,41 +114,65 @@ func newHTTPImageFinder(name, uri string) (ImageFinder, error) {
 		if u == nil {
 			return nil, hes.New("get http upstream fail")
 		}
-		return GetImageFromURL(ctx, u.URL.String()+requestURI)
+		storageURL, err := resolveStorageURL(u.URL, requestURI)
+		if err != nil {
+			return nil, err
+		}
+		return getImageFromStorageURL(ctx, ins, storageURL)
+	}
+	return &storageClient{
+		finder: finder,
+		blob: &httpBlob{
+			upstream: uh,
+			ins:      ins,
+		},
+		ping: func(_ context.Context) error {
+			uh.DoHealthCheck()
+			if uh.PolicyRoundRobin() == nil {
+				return hes.New("get http upstream fail")
+			}
+			return nil
+		},
+		close: uh.StopHealthCheck,
 	}, nil
 }
 
-func newMinioImageFinder(_, uri string) (ImageFinder, error) {
-	urlInfo, err := url.Parse(uri)
-	if err != nil {
-		return nil, err
-	}
-	accessKey := urlInfo.Query().Get("accessKey")
-	secretKey := urlInfo.Query().Get("secretKey")
-	minioClient, err := minio.New(urlInfo.Host, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: false,
-	})
+// newS3Storage 初始化s3兼容的storage(minio、aws s3等)，
+// minio默认不使用https，s3默认使用https
+func newS3Storage(uri string, defaultSecure bool) (*storageClient, error) {
+	minioClient, transport, err := newS3Client(uri, defaultSecure)
 	if err != nil {
 		return nil, err
 	}
-	return func(ctx context.Context, params ...string) (*Image, error) {
+	finder := func(ctx context.Context, params ...string) (*Image, error) {
 		if len(params) != 2 {
-			return nil, hes.New("minio params is invalid")
+			return nil, hes.New("s3 params is invalid")
 		}
 		obj, err := minioClient.GetObject(ctx, params[0], params[1], minio.GetObjectOptions{})
 		if err != nil {
 			return nil, err
 		}
-		buf, err := io.ReadAll(obj)
+		defer obj.Close()
+		buf, err := ReadImageData(obj)
 		if err != nil {
 			return nil, err
 		}
 		return NewImageFromBytes(buf)
+	}
+	return &storageClient{
+		finder: finder,
+		blob: &minioBlob{
+			client: minioClient,
+		},
+		ping: func(ctx context.Context) error {
+			_, err := minioClient.ListBuckets(ctx)
+			return err
+		},
+		close: transport.CloseIdleConnections,
 	}, nil
 }
 
-func newMongoImageFinder(name, uri string) (ImageFinder, error) {
+func newMongoStorage(uri string) (*storageClient, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	cs, err := connstring.ParseAndValidate(uri)
@@ -133,8 +188,7 @@ func newMongoImageFinder(name, uri string) (ImageFinder, error) {
 		return nil, err
 	}
 
-	mongoClients.Store(name, client)
-	return func(ctx context.Context, params ...string) (*Image, error) {
+	finder := func(ctx context.Context, params ...string) (*Image, error) {
 		if len(params) == 0 {
 			return nil, hes.New("gridfs params is invalid")
 		}
@@ -147,20 +201,47 @@ func newMongoImageFinder(name, uri string) (ImageFinder, error) {
 		if err != nil {
 			return nil, err
 		}
-		buffer := bytes.Buffer{}
+		// 非object id的则按文件名获取(由writer写入的文件)
+		var stream *gridfs.DownloadStream
 		id, err := primitive.ObjectIDFromHex(params[0])
+		if err == nil {
+			stream, err = bucket.OpenDownloadStream(id)
+		} else {
+			stream, err = bucket.OpenDownloadStreamByName(params[0])
+		}
 		if err != nil {
 			return nil, err
 		}
-		_, err = bucket.DownloadToStream(id, &buffer)
+		defer stream.Close()
+		buf, err := ReadImageData(stream)
 		if err != nil {
 			return nil, err
 		}
-		return NewImageFromBytes(buffer.Bytes())
+		return NewImageFromBytes(buf)
+	}
+	return &storageClient{
+		finder: finder,
+		blob: &gridfsBlob{
+			client:   client,
+			database: cs.Database,
+		},
+		ping: func(ctx context.Context) error {
+			return client.Ping(ctx, nil)
+		},
+		close: func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := client.Disconnect(ctx)
+			if err != nil {
+				log.Error(ctx).
+					Err(err).
+					Msg("disconnect mongodb fail")
+			}
+		},
 	}, nil
 }
 
-func newOSSImageFinder(_, uri string) (ImageFinder, error) {
+func newOSSStorage(uri string) (*storageClient, error) {
 	urlInfo, err := url.Parse(uri)
 	if err != nil {
 		return nil, err
@@ -175,7 +256,7 @@ func newOSSImageFinder(_, uri string) (ImageFinder, error) {
 		return nil, err
 	}
 
-	return func(_ context.Context, params ...string) (*Image, error) {
+	finder := func(_ context.Context, params ...string) (*Image, error) {
 		if len(params) != 2 {
 			return nil, hes.New("oss params is invalid")
 		}
@@ -187,17 +268,30 @@ func newOSSImageFinder(_, uri string) (ImageFinder, error) {
 		if err != nil {
 			return nil, err
 		}
-		buf, err := io.ReadAll(r)
+		defer r.Close()
+		buf, err := ReadImageData(r)
 		if err != nil {
 			return nil, err
 		}
 
 		return NewImageFromBytes(buf)
+	}
+	return &storageClient{
+		finder: finder,
+		blob: &ossBlob{
+			client: client,
+		},
+		ping: func(_ context.Context) error {
+			_, err := client.ListBuckets(oss.MaxKeys(1))
+			return err
+		},
 	}, nil
 }
 
+// GetImageFromURL 获取外部图片，地址需通过allow/deny的校验，
+// 而且数据类型需为图片以及长度不超过限制
 func GetImageFromURL(ctx context.Context, url string) (*Image, error) {
-	resp, err := axios.GetDefaultInstance().GetX(ctx, url)
+	resp, err := fetchIns.GetX(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -207,43 +301,186 @@ func GetImageFromURL(ctx context.Context, url string) (*Image, error) {
 	return NewImageFromBytes(resp.Data)
 }
 
-func InitImageFinder(ctx context.Context) error {
+// getImageFromStorageURL 从http storage中获取图片，地址为管理员配置的，
+// 因此不做allow/deny的校验
+func getImageFromStorageURL(ctx context.Context, ins *axios.Instance, url string) (*Image, error) {
+	resp, err := ins.GetX(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != 200 {
+		return nil, hes.New("get image fail")
+	}
+	return NewImageFromBytes(resp.Data)
+}
+
+// resolveStorageURI 如果以$开头，则从env中获取
+func resolveStorageURI(uri string) string {
+	if strings.HasPrefix(uri, "$") {
+		return os.Getenv(uri[1:])
+	}
+	return uri
+}
+
+func newStorageClient(name, category, uri string) (*storageClient, error) {
+	client, err := doNewStorageClient(name, category, uri)
+	if err != nil {
+		return nil, err
+	}
+	client.category = category
+	return client, nil
+}
+
+func doNewStorageClient(name, category, uri string) (*storageClient, error) {
+	switch category {
+	case schema.StorageCategoryHTTP:
+		return newHTTPStorage(uri)
+	case schema.StorageCategoryMinio:
+		return newS3Storage(uri, false)
+	case schema.StorageCategoryS3:
+		return newS3Storage(uri, true)
+	case schema.StorageCategoryGridfs:
+		return newMongoStorage(uri)
+	case schema.StorageCategoryOSS:
+		return newOSSStorage(uri)
+	case schema.StorageCategoryFile:
+		return newFileStorage(name, uri)
+	}
+	return nil, hes.New("category of storage is not supported")
+}
+
+// closeStorageClient 关闭storage的连接等
+func closeStorageClient(client *storageClient) {
+	if client != nil && client.close != nil {
+		client.close()
+	}
+}
+
+func loadStorageClient(name string) *storageClient {
+	value, ok := storageClients.Load(name)
+	if !ok {
+		return nil
+	}
+	client, _ := value.(*storageClient)
+	return client
+}
+
+// ReloadStorage 重新初始化storage，配置无变化时不处理，非启用状态的则删除。
+// 初始化失败时保留原有的storage并返回出错
+func ReloadStorage(item *ent.Storage) error {
+	if item.Status != schema.StatusEnabled {
+		RemoveStorage(item.Name)
+		return nil
+	}
+	category := item.Category.String()
+	uri := resolveStorageURI(item.URI)
+	version := category + "|" + uri
+
+	storageMutex.Lock()
+	seq := nextStorageSeq(item.Name)
+	prev := loadStorageClient(item.Name)
+	storageMutex.Unlock()
+	if prev != nil && prev.version == version {
+		return nil
+	}
+
+	// 连接可能耗时较长，不加锁避免阻塞其它storage的更新
+	client, err := newStorageClient(item.Name, category, uri)
+	if err != nil {
+		return hes.New("init storage " + item.Name + " fail, " + hes.Wrap(err).Message)
+	}
+	client.version = version
+
+	storageMutex.Lock()
+	// 连接期间已有更新的操作，丢弃此次的连接
+	if storageLatestSeqs[item.Name] != seq {
+		storageMutex.Unlock()
+		closeStorageClient(client)
+		return nil
+	}
+	prev = loadStorageClient(item.Name)
+	storageClients.Store(item.Name, client)
+	if client.finder != nil {
+		finders.Store(item.Name, client.finder)
+	} else {
+		finders.Delete(item.Name)
+	}
+	blobs.Store(item.Name, client.blob)
+	storageMutex.Unlock()
+
+	// 替换后再关闭原有的storage
+	closeStorageClient(prev)
+	return nil
+}
+
+// RemoveStorage 删除storage并关闭其连接
+func RemoveStorage(name string) {
+	storageMutex.Lock()
+	nextStorageSeq(name)
+	prev := loadStorageClient(name)
+	storageClients.Delete(name)
+	finders.Delete(name)
+	blobs.Delete(name)
+	storageStatuses.Delete(name)
+	storageMutex.Unlock()
+
+	closeStorageClient(prev)
+}
+
+// RefreshStorages 根据数据库的配置刷新所有storage，
+// 已删除或非启用的storage则删除，初始化失败的返回出错
+func RefreshStorages(ctx context.Context) error {
 	result, err := helper.EntGetClient().Storage.Query().
-		Where(storage.StatusEQ(schema.StatusEnabled)).
 		All(ctx)
 	if err != nil {
 		return err
 	}
+	he := hes.New("refresh storage fail")
+	names := make(map[string]bool)
 	for _, item := range result {
-		var finder ImageFinder
-		// 如果以$开头，则从env中获取
-		if strings.HasPrefix(item.URI, "$") {
-			item.URI = os.Getenv(item.URI[1:])
-		}
-		var err error
-		switch item.Category {
-		case schema.StorageCategoryHTTP:
-			finder, err = newHTTPImageFinder(item.Name, item.URI)
-		case schema.StorageCategoryMinio:
-			finder, err = newMinioImageFinder(item.Name, item.URI)
-		case schema.StorageCategoryGridfs:
-			finder, err = newMongoImageFinder(item.Name, item.URI)
-		case schema.StorageCategoryOSS:
-			finder, err = newOSSImageFinder(item.Name, item.URI)
-		}
-		// 初始化finder失败时，只输出日志
-		if err != nil {
-			log.Error(ctx).
-				Str("name", item.Name).
-				Str("uri", item.URI).
-				Msg("init finder fail")
-			continue
+		names[item.Name] = true
+		he.Add(ReloadStorage(item))
+	}
+	storageClients.Range(func(key, _ interface{}) bool {
+		name, _ := key.(string)
+		if !names[name] {
+			RemoveStorage(name)
 		}
-		finders.Store(item.Name, finder)
+		return true
+	})
+	if he.IsNotEmpty() {
+		return he
 	}
 	return nil
 }
 
+// InitImageFinder 初始化所有启用的storage，初始化失败时只输出日志
+func InitImageFinder(ctx context.Context) error {
+	err := RefreshStorages(ctx)
+	if err == nil {
+		return nil
+	}
+	he := hes.Wrap(err)
+	// 查询数据库失败
+	if he.IsEmpty() {
+		return err
+	}
+	log.Error(ctx).
+		Err(err).
+		Msg("init finder fail")
+	return nil
+}
+
+// RegisterFinder 注册storage的finder，已存在则替换
+func RegisterFinder(name string, finder ImageFinder) {
+	finders.Store(name, finder)
+}
+
+// UnregisterFinder 删除storage的finder
+func UnregisterFinder(name string) {
+	finders.Delete(name)
+}
+
 func GetFinder(name string) (ImageFinder, error) {
 	value, ok := finders.Load(name)
 	if !ok {
@@ -0,0 +1,108 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/storage"
+	"github.com/vicanso/tiny-site/schema"
+)
+
+func TestReloadStorage(t *testing.T) {
+	assert := assert.New(t)
+
+	name := "reload-test"
+	defer RemoveStorage(name)
+	item := &ent.Storage{
+		Name:     name,
+		Category: storage.Category(schema.StorageCategoryFile),
+		URI:      "file://" + t.TempDir(),
+		Status:   schema.StatusEnabled,
+	}
+	err := ReloadStorage(item)
+	assert.Nil(err)
+	b, err := GetBlob(name)
+	assert.Nil(err)
+	_, err = GetFinder(name)
+	assert.Nil(err)
+	assert.True(IsFileStorage(name))
+
+	// 配置无变化则不重新初始化
+	err = ReloadStorage(item)
+	assert.Nil(err)
+	result, err := GetBlob(name)
+	assert.Nil(err)
+	assert.Equal(b, result)
+
+	// 初始化失败时保留原有的storage
+	item.URI = "file://"
+	err = ReloadStorage(item)
+	assert.NotNil(err)
+	result, err = GetBlob(name)
+	assert.Nil(err)
+	assert.Equal(b, result)
+
+	// 非启用状态则删除
+	item.Status = schema.StatusDisabled
+	err = ReloadStorage(item)
+	assert.Nil(err)
+	_, err = GetBlob(name)
+	assert.Equal(ErrBlobNotFound, err)
+}
+
+func TestCheckStorages(t *testing.T) {
+	assert := assert.New(t)
+
+	name := "health-test"
+	defer RemoveStorage(name)
+	dir := t.TempDir()
+	item := &ent.Storage{
+		Name:     name,
+		Category: storage.Category(schema.StorageCategoryFile),
+		URI:      "file://" + dir,
+		Status:   schema.StatusEnabled,
+	}
+	result := TestStorage(context.Background(), item)
+	assert.Empty(result.Error)
+	// 文件不存在
+	result = TestStorage(context.Background(), item, "a.png")
+	assert.NotEmpty(result.Error)
+
+	err := ReloadStorage(item)
+	assert.Nil(err)
+	statuses, downs := CheckStorages(context.Background(), time.Second)
+	assert.Empty(downs)
+	assert.Equal(1, len(statuses))
+	assert.True(GetStorageStatus(name).Healthy)
+
+	// 删除根目录后检测失败，只在首次失败时返回
+	err = os.RemoveAll(dir)
+	assert.Nil(err)
+	_, downs = CheckStorages(context.Background(), time.Second)
+	assert.Equal(1, len(downs))
+	assert.Equal(name, downs[0].Name)
+	assert.False(GetStorageStatus(name).Healthy)
+	_, downs = CheckStorages(context.Background(), time.Second)
+	assert.Empty(downs)
+
+	RemoveStorage(name)
+	assert.Nil(GetStorageStatus(name))
+}
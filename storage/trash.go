@@ -0,0 +1,141 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/vicanso/hes"
+
+	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/image"
+	"github.com/vicanso/tiny-site/helper"
+	"github.com/vicanso/tiny-site/log"
+)
+
+var (
+	ErrImageInTrash    = hes.NewWithStatusCode("image is in the trash, please restore it or wait for purge", http.StatusConflict)
+	ErrImageNotInTrash = hes.NewWithStatusCode("image is not in the trash", http.StatusNotFound)
+)
+
+// CheckImageNotTrashed 回收站中的图片仍占用名称，
+// 如果该名称的图片在回收站中则返回出错
+func CheckImageNotTrashed(ctx context.Context, bucket, name string) error {
+	trashed, err := helper.EntGetClient().Image.Query().
+		Where(
+			image.Bucket(bucket),
+			image.Name(name),
+			image.DeletedAtNotNil(),
+		).
+		Exist(ctx)
+	if err != nil {
+		return err
+	}
+	if trashed {
+		return ErrImageInTrash
+	}
+	return nil
+}
+
+// TrashImage 将图片移至回收站
+func TrashImage(ctx context.Context, bucket, name, deleter string) error {
+	count, err := helper.EntGetClient().Image.Update().
+		Where(
+			image.Bucket(bucket),
+			image.Name(name),
+			image.DeletedAtIsNil(),
+		).
+		SetDeletedAt(time.Now()).
+		SetDeleter(deleter).
+		Save(ctx)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrImageNotFound
+	}
+	return nil
+}
+
+// RestoreImage 从回收站中恢复图片
+func RestoreImage(ctx context.Context, bucket, name string) error {
+	count, err := helper.EntGetClient().Image.Update().
+		Where(
+			image.Bucket(bucket),
+			image.Name(name),
+			image.DeletedAtNotNil(),
+		).
+		ClearDeletedAt().
+		ClearDeleter().
+		Save(ctx)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrImageNotInTrash
+	}
+	return nil
+}
+
+// PurgeTrashedImages 清除删除时间早于before的图片(包括storage中的数据与历史版本)，
+// 每批清除batchSize张，返回清除的数量
+func PurgeTrashedImages(ctx context.Context, before time.Time, batchSize int) (int, error) {
+	client := helper.EntGetClient()
+	// 通过指定原因的方式删除，删除时记录日志
+	ctx = helper.EntAllowDelete(ctx, "purge trashed image")
+	count := 0
+	for {
+		// 仅查询id与storage的位置，避免加载图片数据
+		result := make([]*ent.Image, 0)
+		err := client.Image.Query().
+			Where(image.DeletedAtLT(before)).
+			Order(ent.Asc(image.FieldID)).
+			Limit(batchSize).
+			Select(
+				image.FieldID,
+				image.FieldBucket,
+				image.FieldName,
+				image.FieldStorage,
+				image.FieldStorageBucket,
+				image.FieldStorageKey,
+			).
+			Scan(ctx, &result)
+		if err != nil {
+			return count, err
+		}
+		if len(result) == 0 {
+			return count, nil
+		}
+		for _, item := range result {
+			// 先删除记录，再删除storage中的数据
+			err = client.Image.DeleteOneID(item.ID).Exec(ctx)
+			if err != nil && !ent.IsNotFound(err) {
+				return count, err
+			}
+			DeleteImageBlob(ctx, GetImageBlobRef(item))
+			// 同时删除其历史版本
+			err = deleteImageVersions(ctx, "purge trashed image", item.Bucket, item.Name, 0)
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+		log.Info(ctx).
+			Int("count", count).
+			Msg("purge trashed images")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny-site/ent"
+	"github.com/vicanso/tiny-site/ent/image"
+	"github.com/vicanso/tiny-site/helper"
+)
+
+func newTestTrashImage(t *testing.T, bucketName, name string, data []byte) {
+	err := Ent().Put(context.Background(), ent.Image{
+		Bucket:   bucketName,
+		Name:     name,
+		Type:     "png",
+		Width:    1,
+		Height:   1,
+		Creator:  "test",
+		Data:     data,
+		Metadata: &http.Header{},
+	})
+	assert.Nil(t, err)
+}
+
+func TestTrashImage(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	bucketName := newTestBucket(t, "")
+	data := []byte("trash")
+	newTestTrashImage(t, bucketName, "trash", data)
+
+	img, err := Ent().Get(ctx, bucketName, "trash")
+	assert.Nil(err)
+	assert.Equal(data, img.Data)
+
+	// 回收站中的图片不可获取
+	assert.Nil(TrashImage(ctx, bucketName, "trash", "test"))
+	_, err = Ent().Get(ctx, bucketName, "trash")
+	assert.True(ent.IsNotFound(err))
+	assert.Equal(ErrImageNotFound, TrashImage(ctx, bucketName, "trash", "test"))
+
+	// 回收站中的图片仍占用名称，不可添加同名的图片
+	assert.Equal(ErrImageInTrash, CheckImageNotTrashed(ctx, bucketName, "trash"))
+	err = Ent().Put(ctx, ent.Image{
+		Bucket:   bucketName,
+		Name:     "trash",
+		Type:     "png",
+		Width:    1,
+		Height:   1,
+		Creator:  "test",
+		Data:     []byte("new"),
+		Metadata: &http.Header{},
+	})
+	assert.Equal(ErrImageInTrash, err)
+
+	// 恢复后可正常获取，数据为删除前的数据
+	assert.Nil(RestoreImage(ctx, bucketName, "trash"))
+	img, err = Ent().Get(ctx, bucketName, "trash")
+	assert.Nil(err)
+	assert.Equal(data, img.Data)
+	assert.Nil(CheckImageNotTrashed(ctx, bucketName, "trash"))
+
+	// 未删除的图片不可恢复
+	assert.Equal(ErrImageNotInTrash, RestoreImage(ctx, bucketName, "trash"))
+	assert.Equal(ErrImageNotInTrash, RestoreImage(ctx, bucketName, "not-exists"))
+}
+
+func TestPurgeTrashedImages(t *testing.T) {
+	assert := assert.New(t)
+
+	storageName := "trash-test"
+	b, err := newFileBlob("file://" + t.TempDir())
+	assert.Nil(err)
+	RegisterBlob(storageName, b)
+	defer UnregisterBlob(storageName)
+
+	ctx := context.Background()
+	bucketName := newTestBucket(t, storageName)
+	newTestTrashImage(t, bucketName, "purge", []byte("purge"))
+	newTestTrashImage(t, bucketName, "keep", []byte("keep"))
+
+	client := helper.EntGetClient()
+	result, err := client.Image.Query().
+		Where(image.Bucket(bucketName), image.Name("purge")).
+		First(ctx)
+	assert.Nil(err)
+	ref := GetImageBlobRef(result)
+	assert.NotNil(ref)
+	assert.Empty(result.Data)
+	buf, err := b.Get(ctx, ref.Bucket, ref.Key)
+	assert.Nil(err)
+	assert.Equal([]byte("purge"), buf)
+
+	assert.Nil(TrashImage(ctx, bucketName, "purge", "test"))
+	// 未超过保留期限的不清除
+	_, err = PurgeTrashedImages(ctx, time.Now().Add(-time.Hour), 10)
+	assert.Nil(err)
+	exists, err := client.Image.Query().
+		Where(image.Bucket(bucketName), image.Name("purge")).
+		Exist(ctx)
+	assert.Nil(err)
+	assert.True(exists)
+
+	count, err := PurgeTrashedImages(ctx, time.Now().Add(time.Second), 1)
+	assert.Nil(err)
+	assert.GreaterOrEqual(count, 1)
+
+	// 记录与storage中的数据均已删除
+	exists, err = client.Image.Query().
+		Where(image.Bucket(bucketName), image.Name("purge")).
+		Exist(ctx)
+	assert.Nil(err)
+	assert.False(exists)
+	_, err = b.Get(ctx, ref.Bucket, ref.Key)
+	assert.True(os.IsNotExist(err))
+
+	// 未删除的图片不受影响
+	img, err := Ent().Get(ctx, bucketName, "keep")
+	assert.Nil(err)
+	assert.Equal([]byte("keep"), img.Data)
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vicanso/go-axios"
+	"github.com/vicanso/hes"
+	"github.com/vicanso/upstream"
+)
+
+// ImageWriter 将图片数据写入storage
+type ImageWriter func(ctx context.Context, bucket, key string, data []byte) error
+
+// ImageDeleter 删除storage中的图片数据
+type ImageDeleter func(ctx context.Context, bucket, key string) error
+
+// GetWriter 获取storage的写入函数
+func GetWriter(name string) (ImageWriter, error) {
+	b, err := GetBlob(name)
+	if err != nil {
+		return nil, hes.New("writer of storage is not found")
+	}
+	return b.Put, nil
+}
+
+// GetDeleter 获取storage的删除函数
+func GetDeleter(name string) (ImageDeleter, error) {
+	b, err := GetBlob(name)
+	if err != nil {
+		return nil, hes.New("deleter of storage is not found")
+	}
+	return b.Delete, nil
+}
+
+// httpBlob 通过http的GET、PUT与DELETE读写图片，地址为/bucket/key
+type httpBlob struct {
+	upstream *upstream.HTTP
+	ins      *axios.Instance
+}
+
+func (b *httpBlob) url(bucket, key string) (string, error) {
+	u := b.upstream.PolicyRoundRobin()
+	if u == nil {
+		return "", hes.New("get http upstream fail")
+	}
+	return resolveStorageURL(u.URL, "/"+url.PathEscape(bucket)+"/"+strings.TrimPrefix(key, "/"))
+}
+
+func (b *httpBlob) do(conf *axios.Config) (*axios.Response, error) {
+	resp, err := b.ins.Request(conf)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status < 200 || resp.Status >= 300 {
+		return nil, hes.NewWithStatusCode(conf.Method+" "+conf.URL+" fail", resp.Status)
+	}
+	return resp, nil
+}
+
+func (b *httpBlob) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	u, err := b.url(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(&axios.Config{
+		Context: ctx,
+		Method:  http.MethodGet,
+		URL:     u,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+func (b *httpBlob) Put(ctx context.Context, bucket, key string, data []byte) error {
+	u, err := b.url(bucket, key)
+	if err != nil {
+		return err
+	}
+	_, err = b.do(&axios.Config{
+		Context: ctx,
+		Method:  http.MethodPut,
+		URL:     u,
+		Body:    data,
+		Headers: http.Header{
+			"Content-Type": []string{"application/octet-stream"},
+		},
+	})
+	return err
+}
+
+func (b *httpBlob) Delete(ctx context.Context, bucket, key string) error {
+	u, err := b.url(bucket, key)
+	if err != nil {
+		return err
+	}
+	_, err = b.do(&axios.Config{
+		Context: ctx,
+		Method:  http.MethodDelete,
+		URL:     u,
+	})
+	return err
+}
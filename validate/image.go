@@ -16,10 +16,21 @@ package validate
 
 func init() {
 	AddAlias("xImageBucket", "min=1,max=20")
+	AddAlias("xImageStorageBucket", "ascii,min=1,max=63")
 	AddAlias("xImageDescription", "min=1,max=100")
 
-	AddAlias("xImageName", "min=1,max=50")
+	// @用于指定版本，如name@v3
+	AddAlias("xImageName", "min=1,max=50,excludes=@")
 	AddAlias("xImageTag", "min=1,max=20")
 	AddAlias("xImageTags", "min=1,max=50")
 	AddAlias("xImageThumbnailSize", "number,max=256")
+	AddAlias("xImagePathOps", "min=1,max=500")
+	AddAlias("xImageExt", "alpha,min=1,max=5")
+	AddAlias("xImageVersion", "min=1")
+	AddAlias("xImageMaxVersions", "min=0,max=100")
+	AddAlias("xImageExifPolicy", "oneof=keep stripGPS stripAll")
+
+	AddAlias("xPipelineTasks", "min=1,max=2000")
+	// 签名的有效期(秒)，最长为1年
+	AddAlias("xPipelineSignTTL", "min=0,max=31536000")
 }
@@ -0,0 +1,21 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+func init() {
+	AddAlias("xPresetName", "ascii,excludesall=/|0x2C,min=1,max=30")
+	AddAlias("xPresetTasks", "min=1,max=1000")
+	AddAlias("xPresetDescription", "min=1,max=100")
+}
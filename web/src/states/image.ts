@@ -34,6 +34,8 @@ export interface Image {
   width: number;
   height: number;
   tags: string[];
+  // EXIF、主色调(Dominant-Color)以及占位图(Blurhash)等信息
+  metadata?: Record<string, string[]>;
   creator: string;
   description: string;
 }
@@ -10,6 +10,15 @@ export enum StorageCategory {
   Minio = "minio",
   OSS = "oss",
   Gridfs = "gridfs",
+  File = "file",
+  S3 = "s3",
+}
+
+export interface StorageHealth {
+  healthy: boolean;
+  latency: number;
+  error?: string;
+  checkedAt: string;
 }
 
 export interface Storage {
@@ -22,6 +31,7 @@ export interface Storage {
   category: string;
   uri: string;
   description?: string;
+  health?: StorageHealth;
 }
 
 const storages: IList<Storage> = reactive({
@@ -9,6 +9,7 @@ import useStorageState, {
   storageFindByID,
   storageAdd,
   storageUpdateByID,
+  StorageHealth,
 } from "../../states/storage";
 import ExForm, { FormItem, FormItemTypes } from "../../components/ExForm";
 import ExTable, {
@@ -41,6 +42,20 @@ function getColumns(): TableColumn[] {
       title: "状态",
       key: "status.desc",
     }),
+    {
+      title: "健康状态",
+      key: "health",
+      render(row: Record<string, unknown>) {
+        const health = row.health as StorageHealth | undefined;
+        if (!health) {
+          return "--";
+        }
+        if (health.healthy) {
+          return `正常(${health.latency}ms)`;
+        }
+        return `异常(${health.error || ""})`;
+      },
+    },
     {
       title: "连接串",
       key: "uri",
@@ -71,7 +86,7 @@ export function getFormItems(params: {
       key: "category",
       placeholder: "请选择存储类型",
       defaultValue: params.category,
-      options: ["http", "minio", "oss", "gridfs"].map((item) => {
+      options: ["http", "minio", "s3", "oss", "gridfs", "file"].map((item) => {
         return {
           label: item,
           value: item,